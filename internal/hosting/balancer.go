@@ -0,0 +1,181 @@
+package hosting
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownStrategy = errors.New("unknown balancing strategy")
+)
+
+type Strategy string
+
+const (
+	StrategyRandom       Strategy = "random"
+	StrategyLeastPlayers Strategy = "least-players"
+	StrategyRoundRobin   Strategy = "round-robin"
+	StrategyWeighted     Strategy = "weighted"
+	StrategyFillFirst    Strategy = "fill-first"
+)
+
+// Candidate is a server that can be chosen by a Balancer.
+type Candidate struct {
+	Name    string
+	Players int
+	Info    InstanceInfo
+}
+
+// IsFull reports whether the candidate reached the maximum player count of its instance.
+func (c Candidate) IsFull() bool {
+	return c.Info.MaxPlayers > 0 && c.Players >= c.Info.MaxPlayers
+}
+
+// Balancer chooses one of the given candidates. Candidates are never empty and never full.
+type Balancer interface {
+	Strategy() Strategy
+	Select(candidates []Candidate) Candidate
+}
+
+func NewBalancer(strategy Strategy) (Balancer, error) {
+	switch strategy {
+	case StrategyRandom:
+		return &RandomBalancer{}, nil
+	case StrategyLeastPlayers:
+		return &LeastPlayersBalancer{}, nil
+	case StrategyRoundRobin:
+		return &RoundRobinBalancer{}, nil
+	case StrategyWeighted:
+		return &WeightedBalancer{}, nil
+	case StrategyFillFirst:
+		return &FillFirstBalancer{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, strategy)
+	}
+}
+
+// choose lets b choose one of the candidates that are neither full nor excluded.
+func choose(b Balancer, candidates []Candidate, exclude []string) (Candidate, error) {
+	candidates = slices.DeleteFunc(candidates, func(c Candidate) bool {
+		return c.IsFull() || slices.Contains(exclude, c.Name)
+	})
+	if len(candidates) == 0 {
+		return Candidate{}, ErrNoServersAvailable
+	}
+
+	return b.Select(candidates), nil
+}
+
+func sortCandidates(candidates []Candidate) {
+	slices.SortFunc(candidates, func(a, b Candidate) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+var _ Balancer = &RandomBalancer{}
+
+type RandomBalancer struct{}
+
+func (b *RandomBalancer) Strategy() Strategy {
+	return StrategyRandom
+}
+
+func (b *RandomBalancer) Select(candidates []Candidate) Candidate {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+var _ Balancer = &LeastPlayersBalancer{}
+
+type LeastPlayersBalancer struct{}
+
+func (b *LeastPlayersBalancer) Strategy() Strategy {
+	return StrategyLeastPlayers
+}
+
+func (b *LeastPlayersBalancer) Select(candidates []Candidate) Candidate {
+	sortCandidates(candidates)
+
+	return slices.MinFunc(candidates, func(a, b Candidate) int {
+		return a.Players - b.Players
+	})
+}
+
+var _ Balancer = &RoundRobinBalancer{}
+
+type RoundRobinBalancer struct {
+	next uint64
+	m    sync.Mutex
+}
+
+func (b *RoundRobinBalancer) Strategy() Strategy {
+	return StrategyRoundRobin
+}
+
+func (b *RoundRobinBalancer) Select(candidates []Candidate) Candidate {
+	sortCandidates(candidates)
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	c := candidates[b.next%uint64(len(candidates))]
+	b.next++
+
+	return c
+}
+
+var _ Balancer = &WeightedBalancer{}
+
+// WeightedBalancer chooses a random candidate with a probability proportional to its weight. Instances
+// without a weight have a weight of 1.
+type WeightedBalancer struct{}
+
+func (b *WeightedBalancer) Strategy() Strategy {
+	return StrategyWeighted
+}
+
+func weightOf(c Candidate) int {
+	if c.Info.Weight <= 0 {
+		return 1
+	}
+
+	return c.Info.Weight
+}
+
+func (b *WeightedBalancer) Select(candidates []Candidate) Candidate {
+	total := 0
+	for _, c := range candidates {
+		total += weightOf(c)
+	}
+
+	n := rand.Intn(total)
+	for _, c := range candidates {
+		n -= weightOf(c)
+		if n < 0 {
+			return c
+		}
+	}
+
+	return candidates[len(candidates)-1]
+}
+
+var _ Balancer = &FillFirstBalancer{}
+
+// FillFirstBalancer chooses the candidate with the most players, so that servers are filled up one after
+// another.
+type FillFirstBalancer struct{}
+
+func (b *FillFirstBalancer) Strategy() Strategy {
+	return StrategyFillFirst
+}
+
+func (b *FillFirstBalancer) Select(candidates []Candidate) Candidate {
+	sortCandidates(candidates)
+
+	return slices.MaxFunc(candidates, func(a, b Candidate) int {
+		return a.Players - b.Players
+	})
+}
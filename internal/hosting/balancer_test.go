@@ -0,0 +1,153 @@
+package hosting
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func candidate(name string, players, maxPlayers, weight int) Candidate {
+	return Candidate{
+		Name:    name,
+		Players: players,
+		Info:    InstanceInfo{MaxPlayers: maxPlayers, Weight: weight},
+	}
+}
+
+func TestNewBalancer(t *testing.T) {
+	for _, strategy := range []Strategy{StrategyRandom, StrategyLeastPlayers, StrategyRoundRobin, StrategyWeighted, StrategyFillFirst} {
+		b, err := NewBalancer(strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b.Strategy() != strategy {
+			t.Errorf("NewBalancer(%q).Strategy() = %q", strategy, b.Strategy())
+		}
+	}
+
+	if _, err := NewBalancer("fastest"); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("expected ErrUnknownStrategy for an unknown strategy, got %v", err)
+	}
+}
+
+func TestBalancers(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategy   Strategy
+		candidates []Candidate
+		exclude    []string
+		// chosen are the candidates expected to be chosen by consecutive selections
+		chosen []string
+	}{
+		{
+			name:       "least players",
+			strategy:   StrategyLeastPlayers,
+			candidates: []Candidate{candidate("a", 5, 0, 0), candidate("b", 2, 0, 0), candidate("c", 3, 0, 0)},
+			chosen:     []string{"b", "b"},
+		},
+		{
+			name:       "least players breaks ties by name",
+			strategy:   StrategyLeastPlayers,
+			candidates: []Candidate{candidate("c", 2, 0, 0), candidate("a", 2, 0, 0), candidate("b", 2, 0, 0)},
+			chosen:     []string{"a"},
+		},
+		{
+			name:       "least players skips excluded servers",
+			strategy:   StrategyLeastPlayers,
+			candidates: []Candidate{candidate("a", 1, 0, 0), candidate("b", 2, 0, 0)},
+			exclude:    []string{"a"},
+			chosen:     []string{"b"},
+		},
+		{
+			name:       "round robin",
+			strategy:   StrategyRoundRobin,
+			candidates: []Candidate{candidate("b", 0, 0, 0), candidate("c", 0, 0, 0), candidate("a", 0, 0, 0)},
+			chosen:     []string{"a", "b", "c", "a"},
+		},
+		{
+			name:       "round robin skips full servers",
+			strategy:   StrategyRoundRobin,
+			candidates: []Candidate{candidate("a", 0, 0, 0), candidate("b", 10, 10, 0), candidate("c", 0, 0, 0)},
+			chosen:     []string{"a", "c", "a"},
+		},
+		{
+			name:       "fill first",
+			strategy:   StrategyFillFirst,
+			candidates: []Candidate{candidate("a", 1, 0, 0), candidate("b", 7, 0, 0), candidate("c", 3, 0, 0)},
+			chosen:     []string{"b"},
+		},
+		{
+			name:       "fill first skips full servers",
+			strategy:   StrategyFillFirst,
+			candidates: []Candidate{candidate("a", 1, 10, 0), candidate("b", 10, 10, 0), candidate("c", 3, 10, 0)},
+			chosen:     []string{"c"},
+		},
+		{
+			name:       "random skips full servers",
+			strategy:   StrategyRandom,
+			candidates: []Candidate{candidate("a", 5, 5, 0), candidate("b", 0, 5, 0)},
+			chosen:     []string{"b", "b", "b"},
+		},
+		{
+			name:       "weighted skips full servers",
+			strategy:   StrategyWeighted,
+			candidates: []Candidate{candidate("a", 20, 20, 1000), candidate("b", 0, 20, 1)},
+			chosen:     []string{"b", "b", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := NewBalancer(test.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, want := range test.chosen {
+				c, err := choose(b, slices.Clone(test.candidates), test.exclude)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if c.Name != want {
+					t.Fatalf("expected selection %d to be %s, got %s", i, want, c.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestBalancersWithoutAvailableServers(t *testing.T) {
+	candidates := []Candidate{candidate("a", 10, 10, 0), candidate("b", 0, 0, 0)}
+
+	for _, strategy := range []Strategy{StrategyRandom, StrategyLeastPlayers, StrategyRoundRobin, StrategyWeighted, StrategyFillFirst} {
+		b, err := NewBalancer(strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := choose(b, slices.Clone(candidates), []string{"b"}); !errors.Is(err, ErrNoServersAvailable) {
+			t.Errorf("expected ErrNoServersAvailable from %s when all servers are full or excluded, got %v", strategy, err)
+		}
+	}
+}
+
+func TestWeightedBalancerDistribution(t *testing.T) {
+	b := &WeightedBalancer{}
+
+	// Instances without a weight count as a weight of 1
+	candidates := []Candidate{candidate("a", 0, 0, 0), candidate("b", 0, 0, 3)}
+
+	const selections = 10000
+
+	chosen := make(map[string]int)
+	for range selections {
+		chosen[b.Select(slices.Clone(candidates)).Name]++
+	}
+
+	// b is expected to be chosen 75% of the time, the bounds are far outside of what chance produces
+	if share := float64(chosen["b"]) / selections; share < 0.7 || share > 0.8 {
+		t.Fatalf("expected b to be chosen about 75%% of the time, got %.1f%%", share*100)
+	}
+}
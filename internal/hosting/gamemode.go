@@ -0,0 +1,161 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Gamemode is the configuration of a gamemode stored in the gamemodes bucket under the gamemode's name.
+type Gamemode struct {
+	Name        string `json:"-"`
+	DisplayName string `json:"displayName,omitempty"`
+	// Default marks the gamemode players join when connecting to the network.
+	Default bool `json:"default,omitempty"`
+	// Fallback is the gamemode players are sent to when they get kicked from a server of this gamemode.
+	Fallback string   `json:"fallback,omitempty"`
+	Strategy Strategy `json:"strategy,omitempty"`
+	// MaxPlayers is the maximum number of players per server, used for instances that don't report their own.
+	MaxPlayers int `json:"maxPlayers,omitempty"`
+	// Permission is required to join servers of this gamemode if set.
+	Permission string `json:"permission,omitempty"`
+}
+
+// GamemodeCatalog is a live view of the gamemodes bucket.
+type GamemodeCatalog struct {
+	kv        kv.Bucket
+	gamemodes map[string]Gamemode
+	m         sync.RWMutex
+	l         zerolog.Logger
+}
+
+// Gamemodes returns the gamemode catalog of the network, which is kept up to date until ctx is cancelled.
+func (h *Hosting) Gamemodes(ctx context.Context) (*GamemodeCatalog, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.gamemodes != nil {
+		return h.gamemodes, nil
+	}
+
+	bucket, err := h.KV().Bucket(ctx, h.Info.KVGamemodesKey())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &GamemodeCatalog{
+		kv:        bucket,
+		gamemodes: make(map[string]Gamemode),
+		l:         log.With().Str("bucket", bucket.Name()).Logger(),
+	}
+
+	watcher, err := kv.WatchResuming(ctx, bucket, ">")
+	if err != nil {
+		return nil, err
+	}
+
+	go c.watch(watcher)
+
+	h.gamemodes = c
+
+	return c, nil
+}
+
+func (c *GamemodeCatalog) watch(watcher kv.Watcher) {
+	for key := range watcher.Changes() {
+		if key == nil {
+			continue
+		}
+
+		switch key.Operation {
+		case kv.Put:
+			gm := Gamemode{}
+			if err := json.Unmarshal(key.Value, &gm); err != nil {
+				c.l.Error().Err(err).Msgf("Failed to unmarshal gamemode %s", key.Key)
+				continue
+			}
+
+			gm.Name = key.Key
+
+			c.l.Trace().Msgf("Gamemode %s changed: %+v", key.Key, gm)
+
+			c.m.Lock()
+			c.gamemodes[key.Key] = gm
+			c.m.Unlock()
+
+		case kv.Delete:
+			c.l.Trace().Msgf("Gamemode %s deleted", key.Key)
+
+			c.m.Lock()
+			delete(c.gamemodes, key.Key)
+			c.m.Unlock()
+		}
+	}
+}
+
+// Get returns the gamemode with the given name. Unknown gamemodes are returned with their name only, so
+// they keep working with default settings.
+func (c *GamemodeCatalog) Get(name string) (Gamemode, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	gm, exists := c.gamemodes[name]
+	if !exists {
+		return Gamemode{Name: name}, false
+	}
+
+	return gm, true
+}
+
+func (c *GamemodeCatalog) All() []Gamemode {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	gamemodes := make([]Gamemode, 0, len(c.gamemodes))
+	for _, gm := range c.gamemodes {
+		gamemodes = append(gamemodes, gm)
+	}
+
+	slices.SortFunc(gamemodes, func(a, b Gamemode) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return gamemodes
+}
+
+// Default returns the default gamemode. If more than one gamemode is marked as default, the first one by
+// name is returned.
+func (c *GamemodeCatalog) Default() (Gamemode, bool) {
+	for _, gm := range c.All() {
+		if gm.Default {
+			return gm, true
+		}
+	}
+
+	return Gamemode{}, false
+}
+
+// FallbackChain returns the names of the gamemodes a player of the given gamemode should be sent to, in
+// order. The chain follows the fallback of every gamemode, stops at the first cycle and ends with the
+// default gamemode. No gamemode appears twice, and name itself is never part of the chain.
+func (c *GamemodeCatalog) FallbackChain(name string) []string {
+	visited := map[string]bool{name: true}
+
+	var chain []string
+	for gm, _ := c.Get(name); gm.Fallback != "" && !visited[gm.Fallback]; gm, _ = c.Get(gm.Fallback) {
+		visited[gm.Fallback] = true
+		chain = append(chain, gm.Fallback)
+	}
+
+	if def, ok := c.Default(); ok && !visited[def.Name] {
+		chain = append(chain, def.Name)
+	}
+
+	return chain
+}
@@ -1,9 +1,14 @@
 package hosting
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/messaging"
@@ -12,10 +17,16 @@ import (
 )
 
 type Hosting struct {
-	strg storage.Storage
-	kv   kv.Client
-	msg  messaging.Messager
-	Info *PodInfo
+	strg         storage.Storage
+	kv           kv.Client
+	msg          messaging.Messager
+	instanceOpts InstanceOptions
+	instanceMgr  *InstanceManager
+	gamemodes    *GamemodeCatalog
+	players      *PlayerRegistry
+	playerTTL    time.Duration
+	m            sync.Mutex
+	Info         *PodInfo
 }
 
 func Init() (*Hosting, error) {
@@ -35,10 +46,12 @@ func Init() (*Hosting, error) {
 	}
 
 	return &Hosting{
-		strg: storageC,
-		kv:   kvC,
-		msg:  msgC,
-		Info: ParsePodInfo(),
+		strg:         storageC,
+		kv:           kvC,
+		msg:          msgC,
+		instanceOpts: initInstanceOptions(),
+		playerTTL:    getEnvDurationWithDefault("PLAYER_PRESENCE_TTL", time.Minute),
+		Info:         ParsePodInfo(),
 	}, nil
 }
 
@@ -54,6 +67,26 @@ func (n *Hosting) Messaging() messaging.Messager {
 	return n.msg
 }
 
+// Close closes messaging, KV and storage, in that order, so that no messages are handled while the KV
+// watchers and storage are shut down.
+func (n *Hosting) Close(ctx context.Context) error {
+	var errs []error
+
+	if err := n.msg.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close messaging: %w", err))
+	}
+
+	if err := n.kv.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close KV: %w", err))
+	}
+
+	if err := n.strg.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close storage: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
 func getEnvWithDefault(key, def string) string {
 	v, exists := os.LookupEnv(key)
 	if !exists {
@@ -77,6 +110,44 @@ func getEnvBoolWithDefault(key string, def bool) bool {
 	return v
 }
 
+func getEnvDurationWithDefault(key string, def time.Duration) time.Duration {
+	raw, exists := os.LookupEnv(key)
+	if !exists {
+		return def
+	}
+
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Fatal().Err(err).Str("key", key).Msg("Failed to parse duration from env")
+	}
+
+	return v
+}
+
+func getEnvIntWithDefault(key string, def int) int {
+	raw, exists := os.LookupEnv(key)
+	if !exists {
+		return def
+	}
+
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Fatal().Err(err).Str("key", key).Msg("Failed to parse integer from env")
+	}
+
+	return v
+}
+
+func initInstanceOptions() InstanceOptions {
+	return InstanceOptions{
+		HeartbeatTTL:    getEnvDurationWithDefault("INSTANCE_HEARTBEAT_TTL", 30*time.Second),
+		HealthInterval:  getEnvDurationWithDefault("INSTANCE_HEALTH_INTERVAL", 10*time.Second),
+		HealthTimeout:   getEnvDurationWithDefault("INSTANCE_HEALTH_TIMEOUT", 3*time.Second),
+		HealthThreshold: getEnvIntWithDefault("INSTANCE_HEALTH_THRESHOLD", 2),
+		ExpiryInterval:  getEnvDurationWithDefault("INSTANCE_EXPIRY_INTERVAL", time.Second),
+	}
+}
+
 func initStorage() (storage.Storage, error) {
 	logging := getEnvBoolWithDefault("STORAGE_LOGGING", false)
 	backend := getEnvWithDefault("STORAGE_BACKEND", "memory")
@@ -127,17 +198,52 @@ func initKV(strg storage.Storage) (kv.Client, error) {
 			return nil, err
 		}
 
-		js, err := connectToJetStream(opts.URL)
+		nc, err := connectToNATS(opts.URL)
 		if err != nil {
 			return nil, err
 		}
 
-		kvC = kv.NewNATSClient(js)
+		// err is shadowed in this case, so it has to be checked here
+		kvC, err = kv.NewNATSClientFromConn(nc)
+		if err != nil {
+			return nil, err
+		}
 
 	case "json":
 		log.Info().Msg("Using JSON as KV backend")
 
-		kvC, err = kv.NewJSONClient(strg, "")
+		opts := kv.JSONOptions{Watchers: kv.DefaultWatcherOptions}
+		if backendOptions != "" {
+			if err := json.Unmarshal([]byte(backendOptions), &opts); err != nil {
+				return nil, err
+			}
+		}
+
+		kvC, err = kv.NewJSONClient(strg, "", kv.WithWatcherOptions(opts.Watchers))
+
+	case "bolt":
+		log.Info().Msg("Using bbolt as KV backend")
+
+		opts := kv.BoltOptions{Path: "kv.db"}
+		if backendOptions != "" {
+			if err := json.Unmarshal([]byte(backendOptions), &opts); err != nil {
+				return nil, err
+			}
+		}
+
+		kvC, err = kv.NewBoltClient(opts)
+
+	case "redis":
+		log.Info().Msg("Using Redis as KV backend")
+
+		opts := kv.RedisOptions{URL: "redis://127.0.0.1:6379/0"}
+		if backendOptions != "" {
+			if err := json.Unmarshal([]byte(backendOptions), &opts); err != nil {
+				return nil, err
+			}
+		}
+
+		kvC, err = kv.NewRedisClient(opts)
 
 	default:
 		log.Fatal().Msgf("unknown KV backend: %s", backend)
@@ -164,6 +270,11 @@ func initMessaging() (messaging.Messager, error) {
 	var msgC messaging.Messager
 
 	switch backend {
+	case "memory":
+		log.Info().Msg("Using memory as messaging backend")
+
+		msgC = messaging.NewMemory()
+
 	case "nats":
 		log.Info().Msg("Using NATS as messaging backend")
 
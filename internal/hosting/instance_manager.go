@@ -2,11 +2,10 @@ package hosting
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
@@ -15,46 +14,144 @@ import (
 )
 
 var (
-	ErrNoServersAvailable = errors.New("no servers available")
+	ErrNoServersAvailable     = errors.New("no servers available")
+	ErrInvalidInstanceOptions = errors.New("invalid instance options")
 )
 
+type InstanceOptions struct {
+	// HeartbeatTTL is the maximum age of an instance's last heartbeat before it is considered dead.
+	HeartbeatTTL time.Duration
+	// HealthInterval is the time between two active health checks of the same instance.
+	HealthInterval time.Duration
+	// HealthTimeout is the maximum time a single health check may take.
+	HealthTimeout time.Duration
+	// HealthThreshold is the number of consecutive failed checks after which an instance is marked unhealthy.
+	HealthThreshold int
+	// ExpiryInterval is the time between two sweeps for instances with stale heartbeats.
+	ExpiryInterval time.Duration
+}
+
+// validate rejects options the instance manager can't run with, like intervals that are not positive.
+func (o InstanceOptions) validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"heartbeat TTL", o.HeartbeatTTL},
+		{"health interval", o.HealthInterval},
+		{"health timeout", o.HealthTimeout},
+		{"expiry interval", o.ExpiryInterval},
+	}
+
+	for _, d := range durations {
+		if d.value <= 0 {
+			return fmt.Errorf("%w: %s must be positive, got %s", ErrInvalidInstanceOptions, d.name, d.value)
+		}
+	}
+
+	if o.HealthThreshold <= 0 {
+		return fmt.Errorf("%w: health threshold must be positive, got %d", ErrInvalidInstanceOptions, o.HealthThreshold)
+	}
+
+	return nil
+}
+
+type instance struct {
+	info     InstanceInfo
+	healthy  bool
+	failures int
+}
+
 type InstanceManager struct {
 	prx         *proxy.Proxy
 	instancesKV kv.Bucket
-	rnd         *rand.Rand
+	gamemodes   *GamemodeCatalog
+	opts        InstanceOptions
+	instances   map[string]*instance
+	balancers   map[string]gamemodeBalancer
+	m           sync.RWMutex
 }
 
+// InstanceManager returns the instance manager of this proxy. It is shared between all plugins, so that
+// health information collected by one of them is visible to the others.
 func (h *Hosting) InstanceManager(ctx context.Context, prx *proxy.Proxy) (*InstanceManager, error) {
-	rnd := rand.New(rand.NewSource(time.Now().Unix()))
+	if err := h.instanceOpts.validate(); err != nil {
+		return nil, err
+	}
+
+	gamemodes, err := h.Gamemodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.instanceMgr != nil {
+		return h.instanceMgr, nil
+	}
 
 	instancesKV, err := h.KV().Bucket(ctx, h.Info.KVInstancesKey())
 	if err != nil {
 		return nil, err
 	}
 
-	return &InstanceManager{
+	h.instanceMgr = &InstanceManager{
 		prx:         prx,
 		instancesKV: instancesKV,
-		rnd:         rnd,
-	}, nil
+		gamemodes:   gamemodes,
+		opts:        h.instanceOpts,
+		instances:   make(map[string]*instance),
+		balancers:   make(map[string]gamemodeBalancer),
+	}
+
+	return h.instanceMgr, nil
 }
 
 func (m *InstanceManager) Register(ctx context.Context, name string, info InstanceInfo) error {
+	if info.IsExpired(m.opts.HeartbeatTTL, time.Now()) {
+		log.Warn().Msgf("Ignoring server %s with stale heartbeat from %s", name, info.LastHeartbeat)
+		return m.expire(ctx, name)
+	}
+
 	ip, err := net.ResolveTCPAddr("tcp4", fmt.Sprintf("%s:%d", info.Address, info.Port))
 	if err != nil {
 		return err
 	}
 
+	// Heartbeats rewrite the instance key regularly, only re-register the server if its address changed
+	if s := m.prx.Server(name); s != nil && s.ServerInfo().Addr().String() == ip.String() {
+		m.m.Lock()
+		if i, exists := m.instances[name]; exists {
+			i.info = info
+		} else {
+			m.instances[name] = &instance{info: info, healthy: true}
+		}
+		m.m.Unlock()
+
+		return nil
+	}
+
 	if err := m.Unregister(ctx, name); err != nil {
 		return err
 	}
 
-	_, err = m.prx.Register(proxy.NewServerInfo(name, ip))
+	if _, err := m.prx.Register(proxy.NewServerInfo(name, ip)); err != nil {
+		return err
+	}
+
+	m.m.Lock()
+	m.instances[name] = &instance{info: info, healthy: true}
+	m.m.Unlock()
 
-	return err
+	return nil
 }
 
 func (m *InstanceManager) Unregister(ctx context.Context, name string) error {
+	m.m.Lock()
+	delete(m.instances, name)
+	m.m.Unlock()
+
 	s := m.prx.Server(name)
 	if s == nil {
 		return nil
@@ -67,50 +164,282 @@ func (m *InstanceManager) Unregister(ctx context.Context, name string) error {
 	return nil
 }
 
-func (m *InstanceManager) GetServersOfGamemode(ctx context.Context, gamemode string) ([]proxy.RegisteredServer, error) {
-	keys, err := m.instancesKV.ListKeys(ctx)
-	if err != nil {
-		return nil, err
+// ExpireStale unregisters every instance whose last heartbeat is older than the configured TTL and removes
+// it from the instances bucket. It returns the names of the expired instances.
+func (m *InstanceManager) ExpireStale(ctx context.Context) ([]string, error) {
+	now := time.Now()
+
+	var expired []string
+
+	m.m.RLock()
+	for name, i := range m.instances {
+		if i.info.IsExpired(m.opts.HeartbeatTTL, now) {
+			expired = append(expired, name)
+		}
 	}
+	m.m.RUnlock()
 
-	var servers []proxy.RegisteredServer
-	for _, key := range keys {
-		v, err := m.instancesKV.Get(ctx, key)
-		if err != nil {
-			return nil, err
+	for _, name := range expired {
+		log.Warn().Msgf("Heartbeat of server %s expired", name)
+
+		if err := m.expire(ctx, name); err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// expire unregisters an instance with a stale heartbeat and deletes its key, so it is not registered again
+// by the next replay of the instances bucket.
+func (m *InstanceManager) expire(ctx context.Context, name string) error {
+	if err := m.Unregister(ctx, name); err != nil {
+		return err
+	}
+
+	// Every proxy runs the sweep, so another one may have deleted the key already
+	if err := m.instancesKV.Delete(ctx, name); err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// RunExpiry expires instances with stale heartbeats every ExpiryInterval until ctx is cancelled.
+func (m *InstanceManager) RunExpiry(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.ExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.ExpireStale(ctx); err != nil {
+				log.Error().Err(err).Msg("Failed to expire stale servers")
+			}
+		}
+	}
+}
+
+// RunHealthChecks actively probes all registered instances until ctx is cancelled. Instances failing
+// HealthThreshold consecutive probes are excluded from server selection until a probe succeeds again.
+func (m *InstanceManager) RunHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkHealth(ctx)
+		}
+	}
+}
+
+func (m *InstanceManager) checkHealth(ctx context.Context) {
+	m.m.RLock()
+	addrs := make(map[string]string, len(m.instances))
+	for name, i := range m.instances {
+		addrs[name] = net.JoinHostPort(i.info.Address, fmt.Sprint(i.info.Port))
+	}
+	m.m.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, addr := range addrs {
+		wg.Add(1)
+
+		go func(name, addr string) {
+			defer wg.Done()
+
+			err := probe(ctx, addr, m.opts.HealthTimeout)
+			m.reportHealth(name, err)
+		}(name, addr)
+	}
+
+	wg.Wait()
+}
+
+func (m *InstanceManager) reportHealth(name string, err error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	i, exists := m.instances[name]
+	if !exists {
+		return
+	}
+
+	if err == nil {
+		if !i.healthy {
+			log.Info().Msgf("Server %s recovered", name)
 		}
 
-		info := InstanceInfo{}
-		if err := json.Unmarshal(v, &info); err != nil {
-			log.Error().Err(err).Msg("Failed to unmarshal instance info")
+		i.failures = 0
+		i.healthy = true
+
+		return
+	}
+
+	i.failures++
+	if i.healthy && i.failures >= m.opts.HealthThreshold {
+		log.Warn().Err(err).Msgf("Server %s is unhealthy after %d failed health checks", name, i.failures)
+		i.healthy = false
+	}
+}
+
+func probe(ctx context.Context, addr string, timeout time.Duration) error {
+	d := net.Dialer{Timeout: timeout}
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// IsHealthy reports whether the instance is registered and passed its last health checks.
+func (m *InstanceManager) IsHealthy(name string) bool {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	i, exists := m.instances[name]
+
+	return exists && i.healthy
+}
+
+func (m *InstanceManager) candidatesOfGamemode(gamemode string) []Candidate {
+	now := time.Now()
+	gm, _ := m.gamemodes.Get(gamemode)
+
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	var candidates []Candidate
+	for name, i := range m.instances {
+		if i.info.Gamemode != gamemode {
 			continue
 		}
 
-		if info.Gamemode != gamemode {
+		if !i.healthy || i.info.IsExpired(m.opts.HeartbeatTTL, now) {
 			continue
 		}
 
-		s := m.prx.Server(key)
+		s := m.prx.Server(name)
 		if s == nil {
-			log.Warn().Msgf("Server %s not found in registry", key)
+			log.Warn().Msgf("Server %s not found in registry", name)
 			continue
 		}
 
-		servers = append(servers, s)
+		info := i.info
+		if info.MaxPlayers == 0 {
+			info.MaxPlayers = gm.MaxPlayers
+		}
+
+		candidates = append(candidates, Candidate{
+			Name:    name,
+			Players: s.Players().Len(),
+			Info:    info,
+		})
+	}
+
+	return candidates
+}
+
+func (m *InstanceManager) GetServersOfGamemode(ctx context.Context, gamemode string) ([]proxy.RegisteredServer, error) {
+	var servers []proxy.RegisteredServer
+	for _, c := range m.candidatesOfGamemode(gamemode) {
+		if s := m.prx.Server(c.Name); s != nil {
+			servers = append(servers, s)
+		}
 	}
 
 	return servers, nil
 }
 
-func (m *InstanceManager) GetRandomServerOfGamemode(ctx context.Context, gamemode string) (proxy.RegisteredServer, error) {
-	servers, err := m.GetServersOfGamemode(ctx, gamemode)
+// gamemodeBalancer is the balancer of a gamemode together with the strategy configured when it was
+// created, which is not the strategy of the balancer if the configured one is unknown.
+type gamemodeBalancer struct {
+	Balancer
+	strategy Strategy
+}
+
+// balancer returns the balancer of the strategy configured for the gamemode. Unknown strategies fall back
+// to the random balancer, so a typo in the configuration doesn't keep players from joining the gamemode.
+func (m *InstanceManager) balancer(gamemode string) Balancer {
+	gm, _ := m.gamemodes.Get(gamemode)
+	if gm.Strategy == "" {
+		gm.Strategy = StrategyRandom
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	// Balancers are kept per gamemode, as some of them like round-robin are stateful
+	cached, exists := m.balancers[gamemode]
+	if exists && cached.strategy == gm.Strategy {
+		return cached.Balancer
+	}
+
+	b, err := NewBalancer(gm.Strategy)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Falling back to the %s strategy for gamemode %s", StrategyRandom, gamemode)
+		b = &RandomBalancer{}
+	}
+
+	m.balancers[gamemode] = gamemodeBalancer{Balancer: b, strategy: gm.Strategy}
+
+	return b
+}
+
+// ChooseServerOfGamemode chooses a healthy server of the gamemode that isn't full, using the balancing
+// strategy configured for the gamemode. Servers listed in exclude are never chosen.
+func (m *InstanceManager) ChooseServerOfGamemode(ctx context.Context, gamemode string, exclude ...string) (proxy.RegisteredServer, error) {
+	c, err := choose(m.balancer(gamemode), m.candidatesOfGamemode(gamemode), exclude)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(servers) == 0 {
+	s := m.prx.Server(c.Name)
+	if s == nil {
 		return nil, ErrNoServersAvailable
 	}
 
-	return servers[m.rnd.Intn(len(servers))], nil
+	return s, nil
+}
+
+// ChooseServerForPlayer chooses a server of the first gamemode in gamemodes that has a server available and
+// that the player is allowed to join.
+func (m *InstanceManager) ChooseServerForPlayer(ctx context.Context, player proxy.Player, gamemodes []string, exclude ...string) (proxy.RegisteredServer, Gamemode, error) {
+	for _, name := range gamemodes {
+		gm, _ := m.gamemodes.Get(name)
+		if gm.Permission != "" && !player.HasPermission(gm.Permission) {
+			continue
+		}
+
+		s, err := m.ChooseServerOfGamemode(ctx, name, exclude...)
+		if errors.Is(err, ErrNoServersAvailable) {
+			continue
+		} else if err != nil {
+			return nil, gm, err
+		}
+
+		return s, gm, nil
+	}
+
+	return nil, Gamemode{}, ErrNoServersAvailable
+}
+
+// Instance returns the info of a registered instance.
+func (m *InstanceManager) Instance(name string) (InstanceInfo, bool) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	i, exists := m.instances[name]
+	if !exists {
+		return InstanceInfo{}, false
+	}
+
+	return i.info, true
 }
@@ -0,0 +1,560 @@
+package kv
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/subject"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ Client = &BoltClient{}
+
+type BoltOptions struct {
+	// Path is the database file, created if it does not exist.
+	Path string `json:"path"`
+}
+
+var (
+	// boltKeysBucket is the nested bucket holding the keys of a KV bucket.
+	boltKeysBucket = []byte("keys")
+	// boltOptionsKey holds the options of a KV bucket, so they survive restarts.
+	boltOptionsKey = []byte("options")
+)
+
+// boltHeaderSize is the size of the revision and expiry stored in front of every value.
+const boltHeaderSize = 16
+
+// BoltClient stores every KV bucket as a bucket of a bbolt database file. Writes are transactional and
+// only touch the changed key, unlike the JSON client which rewrites the whole file.
+type BoltClient struct {
+	db          *bolt.DB
+	buckets     map[string]*BoltBucket
+	m           sync.Mutex
+	done        chan struct{}
+	stopped     chan struct{}
+	once        sync.Once
+	watcherOpts WatcherOptions
+}
+
+func NewBoltClient(opts BoltOptions, clientOpts ...ClientOption) (*BoltClient, error) {
+	db, err := bolt.Open(opts.Path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &BoltClient{
+		db:          db,
+		buckets:     make(map[string]*BoltBucket),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		watcherOpts: newClientOptions(clientOpts).watcher,
+	}
+
+	go c.run(ExpiryInterval)
+
+	return c, nil
+}
+
+// run removes expired keys until the client is closed.
+func (c *BoltClient) run(interval time.Duration) {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.m.Lock()
+			buckets := make([]*BoltBucket, 0, len(c.buckets))
+			for _, b := range c.buckets {
+				buckets = append(buckets, b)
+			}
+			c.m.Unlock()
+
+			for _, b := range buckets {
+				if err := b.expire(now); err != nil {
+					log.Error().Err(err).Str("bucket", b.Name()).Msg("Failed to remove expired keys")
+				}
+			}
+		}
+	}
+}
+
+func (c *BoltClient) Bucket(ctx context.Context, name string, opts ...BucketOption) (Bucket, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	b, exists := c.buckets[name]
+	if !exists {
+		b = &BoltBucket{
+			name:        name,
+			db:          c.db,
+			watcherOpts: c.watcherOpts,
+		}
+	}
+
+	stored := storedBucketOptions{}
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		top, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+
+		if _, err := top.CreateBucketIfNotExists(boltKeysBucket); err != nil {
+			return err
+		}
+
+		if raw := top.Get(boltOptionsKey); raw != nil {
+			if err := json.Unmarshal(raw, &stored); err != nil {
+				return err
+			}
+		}
+
+		// History, replicas and the storage type have no meaning for a single database file
+		if len(opts) == 0 {
+			return nil
+		}
+
+		stored = stored.apply(opts)
+
+		raw, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+
+		return top.Put(boltOptionsKey, raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Writes lock the bucket before starting a transaction, so it must not be locked inside one
+	b.m.Lock()
+	b.opts = stored
+	b.m.Unlock()
+
+	c.buckets[name] = b
+
+	return b, nil
+}
+
+func (c *BoltClient) Close(ctx context.Context) error {
+	c.once.Do(func() { close(c.done) })
+	<-c.stopped
+
+	c.m.Lock()
+	buckets := make([]*BoltBucket, 0, len(c.buckets))
+	for _, b := range c.buckets {
+		buckets = append(buckets, b)
+	}
+	c.m.Unlock()
+
+	for _, b := range buckets {
+		b.watchers.closeAll()
+	}
+
+	return c.db.Close()
+}
+
+// boltEntry is a value with its revision and expiry, stored as a header in front of the value.
+type boltEntry struct {
+	revision uint64
+	// expiresAt is the expiry in Unix nanoseconds, zero if the key does not expire.
+	expiresAt int64
+	value     []byte
+}
+
+func (e boltEntry) encode() []byte {
+	raw := make([]byte, boltHeaderSize+len(e.value))
+	binary.BigEndian.PutUint64(raw[0:8], e.revision)
+	binary.BigEndian.PutUint64(raw[8:16], uint64(e.expiresAt))
+	copy(raw[boltHeaderSize:], e.value)
+
+	return raw
+}
+
+// decodeBoltEntry decodes a stored entry. The value is copied, as bbolt only keeps it valid during the
+// transaction.
+func decodeBoltEntry(raw []byte) (boltEntry, error) {
+	if len(raw) < boltHeaderSize {
+		return boltEntry{}, errors.New("bolt entry is too short")
+	}
+
+	return boltEntry{
+		revision:  binary.BigEndian.Uint64(raw[0:8]),
+		expiresAt: int64(binary.BigEndian.Uint64(raw[8:16])),
+		value:     slices.Clone(raw[boltHeaderSize:]),
+	}, nil
+}
+
+func (e boltEntry) isExpired(now time.Time) bool {
+	return e.expiresAt != 0 && now.UnixNano() >= e.expiresAt
+}
+
+var _ Bucket = &BoltBucket{}
+
+type BoltBucket struct {
+	name        string
+	db          *bolt.DB
+	opts        storedBucketOptions
+	watchers    queueWatchers
+	watcherOpts WatcherOptions
+	// m serializes the writes of the bucket, so watchers get the changes in order of revision.
+	m sync.Mutex
+}
+
+func (b *BoltBucket) Name() string {
+	return b.name
+}
+
+// keys returns the nested bucket holding the keys, and the bucket holding the revision sequence.
+func (b *BoltBucket) keys(tx *bolt.Tx) (*bolt.Bucket, *bolt.Bucket) {
+	top := tx.Bucket([]byte(b.name))
+
+	return top.Bucket(boltKeysBucket), top
+}
+
+// entry returns the live entry of key, or false if it does not exist or expired.
+func (b *BoltBucket) entry(tx *bolt.Tx, key string, now time.Time) (boltEntry, bool, error) {
+	keys, _ := b.keys(tx)
+
+	raw := keys.Get([]byte(key))
+	if raw == nil {
+		return boltEntry{}, false, nil
+	}
+
+	e, err := decodeBoltEntry(raw)
+	if err != nil {
+		return boltEntry{}, false, err
+	}
+
+	return e, !e.isExpired(now), nil
+}
+
+func (b *BoltBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := b.GetEntry(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Value, nil
+}
+
+func (b *BoltBucket) GetEntry(ctx context.Context, key string) (*Value, error) {
+	var v *Value
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		e, exists, err := b.entry(tx, key, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrKeyNotFound
+		}
+
+		v = &Value{Key: key, Value: e.value, Operation: Put, Revision: e.revision}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (b *BoltBucket) Set(ctx context.Context, key string, value []byte) error {
+	_, err := b.put(key, value, -1, nil)
+	return err
+}
+
+func (b *BoltBucket) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := b.put(key, value, ttl, nil)
+	return err
+}
+
+func (b *BoltBucket) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	return b.put(key, value, -1, func(exists bool, _ uint64) error {
+		if exists {
+			return ErrKeyExists
+		}
+
+		return nil
+	})
+}
+
+func (b *BoltBucket) Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error) {
+	return b.put(key, value, -1, func(exists bool, revision uint64) error {
+		// Like in JetStream, a key that does not exist has revision 0
+		if !exists {
+			revision = 0
+		}
+
+		if revision != expectedRevision {
+			return ErrRevisionMismatch
+		}
+
+		return nil
+	})
+}
+
+// put sets key to expire after ttl, or the TTL of the bucket if negative, and notifies the watchers. If
+// check is set, it is called with the current state of the key inside the transaction and aborts the write
+// if it fails.
+func (b *BoltBucket) put(key string, value []byte, ttl time.Duration, check func(exists bool, revision uint64) error) (uint64, error) {
+	defer b.watchers.deliver()
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.opts.MaxValueSize > 0 && len(value) > int(b.opts.MaxValueSize) {
+		return 0, ErrValueTooLarge
+	}
+
+	if ttl < 0 {
+		ttl = b.opts.TTL
+	}
+
+	var revision uint64
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+
+		if check != nil {
+			current, exists, err := b.entry(tx, key, now)
+			if err != nil {
+				return err
+			}
+
+			if err := check(exists, current.revision); err != nil {
+				return err
+			}
+		}
+
+		keys, top := b.keys(tx)
+
+		var err error
+		revision, err = top.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		e := boltEntry{revision: revision, value: value}
+		if ttl > 0 {
+			e.expiresAt = now.Add(ttl).UnixNano()
+		}
+
+		return keys.Put([]byte(key), e.encode())
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	b.watchers.record(&Value{Key: key, Value: value, Operation: Put, Revision: revision})
+
+	return revision, nil
+}
+
+func (b *BoltBucket) Delete(ctx context.Context, key string) error {
+	defer b.watchers.deliver()
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var revision uint64
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		_, exists, err := b.entry(tx, key, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrKeyNotFound
+		}
+
+		revision, err = b.remove(tx, key)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	b.watchers.record(&Value{Key: key, Operation: Delete, Revision: revision})
+
+	return nil
+}
+
+// remove deletes key and returns the revision of the delete.
+func (b *BoltBucket) remove(tx *bolt.Tx, key string) (uint64, error) {
+	keys, top := b.keys(tx)
+
+	revision, err := top.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+
+	return revision, keys.Delete([]byte(key))
+}
+
+// expire removes the keys that expired at now.
+func (b *BoltBucket) expire(now time.Time) error {
+	defer b.watchers.deliver()
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var deleted []*Value
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		keys, _ := b.keys(tx)
+
+		var expired []string
+		err := keys.ForEach(func(k, raw []byte) error {
+			e, err := decodeBoltEntry(raw)
+			if err != nil {
+				return err
+			}
+
+			if e.isExpired(now) {
+				expired = append(expired, string(k))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			revision, err := b.remove(tx, key)
+			if err != nil {
+				return err
+			}
+
+			deleted = append(deleted, &Value{Key: key, Operation: Delete, Revision: revision})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range deleted {
+		b.watchers.record(v)
+	}
+
+	return nil
+}
+
+func (b *BoltBucket) ListKeys(ctx context.Context) ([]string, error) {
+	keys := make([]string, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		bucket, _ := b.keys(tx)
+
+		return bucket.ForEach(func(k, raw []byte) error {
+			e, err := decodeBoltEntry(raw)
+			if err != nil {
+				return err
+			}
+
+			if !e.isExpired(now) {
+				keys = append(keys, string(k))
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (b *BoltBucket) WatchAll(ctx context.Context) (Watcher, error) {
+	return b.Watch(ctx, ">")
+}
+
+// Watch watches the keys matching pattern. Like in the JSON backend, only the latest revision of every key
+// is kept, so watchers starting from a revision don't get intermediate values and deletes.
+func (b *BoltBucket) Watch(ctx context.Context, pattern string, opts ...WatchOption) (Watcher, error) {
+	o := newWatchOptions(opts)
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var revision uint64
+	var replay []*Value
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		keys, top := b.keys(tx)
+		revision = top.Sequence()
+
+		if o.FromRevision == 0 && o.UpdatesOnly {
+			return nil
+		}
+
+		now := time.Now()
+
+		return keys.ForEach(func(k, raw []byte) error {
+			e, err := decodeBoltEntry(raw)
+			if err != nil {
+				return err
+			}
+
+			if e.isExpired(now) || !subject.Match(pattern, string(k)) || e.revision < o.FromRevision {
+				return nil
+			}
+
+			replay = append(replay, &Value{Key: string(k), Value: e.value, Operation: Put, Revision: e.revision})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.FromRevision > 0 || !o.UpdatesOnly {
+		slices.SortFunc(replay, func(a, b *Value) int {
+			return cmp.Compare(a.Revision, b.Revision)
+		})
+
+		replay = append(replay, nil)
+	}
+
+	w := newQueueWatcher(b, pattern, b.watcherOpts, replay)
+	w.after = revision
+	b.watchers.add(w)
+
+	return w, nil
+}
+
+// Unwatch stops the watcher. The watcher is closed before it is removed from the bucket, which releases a
+// writer blocked on it.
+func (b *BoltBucket) Unwatch(w Watcher) {
+	w_, ok := w.(*QueueWatcher)
+	if !ok {
+		return
+	}
+
+	b.watchers.remove(w_)
+}
@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltKV(t *testing.T) {
+	ctx := context.Background()
+
+	k, err := NewBoltClient(BoltOptions{Path: filepath.Join(t.TempDir(), "kv.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(ctx)
+
+	testKV(ctx, t, k)
+	testKVBucketOptions(ctx, t, k)
+}
+
+func TestBoltKVWatch(t *testing.T) {
+	ctx := context.Background()
+
+	k, err := NewBoltClient(BoltOptions{Path: filepath.Join(t.TempDir(), "kv.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(ctx)
+
+	testKVWatch(ctx, t, WithLogger(k))
+}
+
+func TestBoltKVResumability(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "kv.db")
+
+	{
+		k, err := NewBoltClient(BoltOptions{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bucket1, err := k.Bucket(ctx, "test", WithMaxValueSize(8))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := bucket1.Set(ctx, "test", []byte("test")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := k.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		k, err := NewBoltClient(BoltOptions{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer k.Close(ctx)
+
+		bucket1, err := k.Bucket(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry, err := bucket1.GetEntry(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(entry.Value) != "test" {
+			t.Fatalf("expected value to be 'test', got '%s'", string(entry.Value))
+		}
+
+		if _, err := bucket1.Update(ctx, "test", []byte("test2"), entry.Revision); err != nil {
+			t.Fatalf("expected the revision to be restored, got %v", err)
+		}
+
+		if err := bucket1.Set(ctx, "test", []byte("too large value")); !errors.Is(err, ErrValueTooLarge) {
+			t.Fatalf("expected the bucket options to be restored, got %v", err)
+		}
+	}
+}
@@ -1,38 +1,91 @@
 package kv
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/storage"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/subject"
+	"github.com/rs/zerolog/log"
 )
 
 var _ Client = &JSONClient{}
 
+// ExpiryInterval is how often the JSON, bbolt and Redis clients remove expired keys. Until then, expired
+// keys are hidden from reads.
+var ExpiryInterval = time.Second
+
+// JSONOptions configure the JSON client.
+type JSONOptions struct {
+	// Watchers configure the delivery of changes to the watchers of all buckets.
+	Watchers WatcherOptions `json:"watchers"`
+}
+
 type JSONClient struct {
 	fileName string
 	store    storage.Storage
 	buckets  map[string]*JSONBucket
 	m        sync.RWMutex
+	done     chan struct{}
+	// stopped is closed once the goroutine removing expired keys returned.
+	stopped chan struct{}
+	once    sync.Once
+	// watcherOpts configure the delivery of changes to the watchers of all buckets.
+	watcherOpts WatcherOptions
 }
 
-func NewJSONClient(store storage.Storage, fileName string) (*JSONClient, error) {
+func NewJSONClient(store storage.Storage, fileName string, opts ...ClientOption) (*JSONClient, error) {
 	c := &JSONClient{
-		fileName: fileName,
-		store:    store,
-		buckets:  make(map[string]*JSONBucket),
+		fileName:    fileName,
+		store:       store,
+		buckets:     make(map[string]*JSONBucket),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		watcherOpts: newClientOptions(opts).watcher,
 	}
 
 	if err := c.init(context.Background()); err != nil {
 		return nil, err
 	}
 
+	go c.run(ExpiryInterval)
+
 	return c, nil
 }
 
+// run removes expired keys until the client is closed.
+func (j *JSONClient) run(interval time.Duration) {
+	defer close(j.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case now := <-ticker.C:
+			j.m.RLock()
+			buckets := make([]*JSONBucket, 0, len(j.buckets))
+			for _, b := range j.buckets {
+				buckets = append(buckets, b)
+			}
+			j.m.RUnlock()
+
+			for _, b := range buckets {
+				if err := b.expire(context.Background(), now); err != nil {
+					log.Error().Err(err).Str("bucket", b.Name()).Msg("Failed to remove expired keys")
+				}
+			}
+		}
+	}
+}
+
 func (j *JSONClient) init(ctx context.Context) error {
 	reader, err := j.store.ReadStreaming(ctx, j.fileName)
 	if err != nil {
@@ -54,22 +107,24 @@ func (j *JSONClient) init(ctx context.Context) error {
 
 	for _, b := range j.buckets {
 		b.save = func(ctx context.Context) error { return j.save(ctx) }
-		b.watchers = make([]*JSONWatcher, 0)
+		b.watcherOpts = j.watcherOpts
+		b.initRevisions()
 	}
 
 	return nil
 }
 
 func (j *JSONClient) save(ctx context.Context) error {
+	// The file is closed before unlocking, so concurrent saves don't overwrite each other out of order
+	j.m.Lock()
+	defer j.m.Unlock()
+
 	fd, err := j.store.SaveStreaming(ctx, j.fileName)
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
 
-	j.m.Lock()
-	defer j.m.Unlock()
-
 	if err := json.NewEncoder(fd).Encode(j.buckets); err != nil {
 		return err
 	}
@@ -77,23 +132,33 @@ func (j *JSONClient) save(ctx context.Context) error {
 	return nil
 }
 
-func (j *JSONClient) Bucket(ctx context.Context, name string) (Bucket, error) {
+func (j *JSONClient) Bucket(ctx context.Context, name string, opts ...BucketOption) (Bucket, error) {
 	j.m.RLock()
 	b, exists := j.buckets[name]
 	j.m.RUnlock()
 
 	if !exists {
 		b = &JSONBucket{
-			BucketName: name,
-			Data:       make(map[string][]byte),
-			save:       func(ctx context.Context) error { return j.save(ctx) },
-			watchers:   make([]*JSONWatcher, 0),
+			BucketName:  name,
+			Data:        make(map[string][]byte),
+			Revisions:   make(map[string]uint64),
+			save:        func(ctx context.Context) error { return j.save(ctx) },
+			watcherOpts: j.watcherOpts,
 		}
 		j.m.Lock()
 		j.buckets[name] = b
 		j.m.Unlock()
 	}
 
+	// History, replicas and the storage type have no meaning for a single JSON file
+	if len(opts) > 0 {
+		b.m.Lock()
+		stored := storedBucketOptions{TTL: b.TTL, MaxValueSize: b.MaxValueSize}.apply(opts)
+		b.TTL = stored.TTL
+		b.MaxValueSize = stored.MaxValueSize
+		b.m.Unlock()
+	}
+
 	if err := j.save(ctx); err != nil {
 		return nil, err
 	}
@@ -101,63 +166,243 @@ func (j *JSONClient) Bucket(ctx context.Context, name string) (Bucket, error) {
 	return b, nil
 }
 
+func (j *JSONClient) Close(ctx context.Context) error {
+	j.once.Do(func() { close(j.done) })
+	<-j.stopped
+
+	j.m.RLock()
+	buckets := make([]*JSONBucket, 0, len(j.buckets))
+	for _, b := range j.buckets {
+		buckets = append(buckets, b)
+	}
+	j.m.RUnlock()
+
+	for _, b := range buckets {
+		b.watchers.closeAll()
+	}
+
+	return j.save(ctx)
+}
+
 var _ Bucket = &JSONBucket{}
 
 type JSONBucket struct {
 	BucketName string            `json:"name"`
 	Data       map[string][]byte `json:"data"`
-	watchers   []*JSONWatcher
-	m          sync.RWMutex
-	save       func(ctx context.Context) error
+	// Revision is the revision of the last change to the bucket.
+	Revision uint64 `json:"revision"`
+	// Revisions holds the revision of the last change to every key.
+	Revisions map[string]uint64 `json:"revisions"`
+	// Expires holds the expiry of the keys written with a TTL.
+	Expires      map[string]time.Time `json:"expires,omitempty"`
+	TTL          time.Duration        `json:"ttl,omitempty"`
+	MaxValueSize int32                `json:"maxValueSize,omitempty"`
+	watchers     queueWatchers
+	watcherOpts  WatcherOptions
+	m            sync.RWMutex
+	save         func(ctx context.Context) error
+}
+
+// MarshalJSON locks the bucket, so it can be saved while keys are changed concurrently.
+func (b *JSONBucket) MarshalJSON() ([]byte, error) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	type bucket JSONBucket
+
+	return json.Marshal((*bucket)(b))
+}
+
+// initRevisions assigns revisions to the keys of buckets saved before revisions were introduced.
+func (b *JSONBucket) initRevisions() {
+	if b.Revisions == nil {
+		b.Revisions = make(map[string]uint64)
+	}
+
+	keys := make([]string, 0, len(b.Data))
+	for k := range b.Data {
+		if _, exists := b.Revisions[k]; !exists {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		b.Revision++
+		b.Revisions[k] = b.Revision
+	}
 }
 
 func (b *JSONBucket) Name() string {
 	return b.BucketName
 }
 
+// exists reports whether key is set and not expired at now. b.m must be locked.
+func (b *JSONBucket) exists(key string, now time.Time) bool {
+	if _, exists := b.Data[key]; !exists {
+		return false
+	}
+
+	expiresAt, expires := b.Expires[key]
+
+	return !expires || now.Before(expiresAt)
+}
+
 func (b *JSONBucket) Get(ctx context.Context, key string) ([]byte, error) {
 	b.m.RLock()
-	v, exists := b.Data[key]
-	b.m.RUnlock()
+	defer b.m.RUnlock()
 
-	if !exists {
+	if !b.exists(key, time.Now()) {
+		return nil, ErrKeyNotFound
+	}
+
+	return b.Data[key], nil
+}
+
+func (b *JSONBucket) GetEntry(ctx context.Context, key string) (*Value, error) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	if !b.exists(key, time.Now()) {
 		return nil, ErrKeyNotFound
 	}
 
-	return v, nil
+	return &Value{Key: key, Value: b.Data[key], Operation: Put, Revision: b.Revisions[key]}, nil
 }
 
 func (b *JSONBucket) Set(ctx context.Context, key string, value []byte) error {
 	b.m.Lock()
-	b.Data[key] = value
+	_, err := b.put(key, value, b.TTL)
+	b.m.Unlock()
+	b.watchers.deliver()
 
-	for _, w := range b.watchers {
-		w.m.Lock()
-		w.changes <- &Value{Key: key, Value: value, Operation: Put}
-		w.m.Unlock()
+	if err != nil {
+		return err
 	}
 
+	return b.save(ctx)
+}
+
+func (b *JSONBucket) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	b.m.Lock()
+	_, err := b.put(key, value, ttl)
 	b.m.Unlock()
+	b.watchers.deliver()
+
+	if err != nil {
+		return err
+	}
 
 	return b.save(ctx)
 }
 
-func (b *JSONBucket) Delete(ctx context.Context, key string) error {
+func (b *JSONBucket) Create(ctx context.Context, key string, value []byte) (uint64, error) {
 	b.m.Lock()
-	if _, exists := b.Data[key]; !exists {
+	if b.exists(key, time.Now()) {
 		b.m.Unlock()
-		return ErrKeyNotFound
+		return 0, ErrKeyExists
+	}
+
+	revision, err := b.put(key, value, b.TTL)
+	b.m.Unlock()
+	b.watchers.deliver()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return revision, b.save(ctx)
+}
+
+func (b *JSONBucket) Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error) {
+	b.m.Lock()
+	// Like in JetStream, a key that does not exist has revision 0
+	var current uint64
+	if b.exists(key, time.Now()) {
+		current = b.Revisions[key]
+	}
+
+	if current != expectedRevision {
+		b.m.Unlock()
+		return 0, ErrRevisionMismatch
+	}
+
+	revision, err := b.put(key, value, b.TTL)
+	b.m.Unlock()
+	b.watchers.deliver()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return revision, b.save(ctx)
+}
+
+// put sets key to expire after ttl, if not zero, and records the change for the watchers. b.m must be
+// locked.
+func (b *JSONBucket) put(key string, value []byte, ttl time.Duration) (uint64, error) {
+	if b.MaxValueSize > 0 && len(value) > int(b.MaxValueSize) {
+		return 0, ErrValueTooLarge
+	}
+
+	b.Revision++
+	b.Data[key] = value
+	b.Revisions[key] = b.Revision
+
+	if ttl > 0 {
+		if b.Expires == nil {
+			b.Expires = make(map[string]time.Time)
+		}
+		b.Expires[key] = time.Now().Add(ttl)
+	} else {
+		delete(b.Expires, key)
 	}
 
+	b.watchers.record(&Value{Key: key, Value: value, Operation: Put, Revision: b.Revision})
+
+	return b.Revision, nil
+}
+
+// remove deletes key and records the change for the watchers. b.m must be locked.
+func (b *JSONBucket) remove(key string) {
+	b.Revision++
 	delete(b.Data, key)
+	delete(b.Revisions, key)
+	delete(b.Expires, key)
 
-	for _, w := range b.watchers {
-		w.m.Lock()
-		w.changes <- &Value{Key: key, Operation: Delete}
-		w.m.Unlock()
+	b.watchers.record(&Value{Key: key, Operation: Delete, Revision: b.Revision})
+}
+
+func (b *JSONBucket) Delete(ctx context.Context, key string) error {
+	b.m.Lock()
+	if !b.exists(key, time.Now()) {
+		b.m.Unlock()
+		return ErrKeyNotFound
 	}
 
+	b.remove(key)
+	b.m.Unlock()
+	b.watchers.deliver()
+
+	return b.save(ctx)
+}
+
+// expire removes the keys that expired at now.
+func (b *JSONBucket) expire(ctx context.Context, now time.Time) error {
+	b.m.Lock()
+	expired := 0
+	for key, expiresAt := range b.Expires {
+		if !now.Before(expiresAt) {
+			b.remove(key)
+			expired++
+		}
+	}
 	b.m.Unlock()
+	b.watchers.deliver()
+
+	if expired == 0 {
+		return nil
+	}
 
 	return b.save(ctx)
 }
@@ -166,71 +411,76 @@ func (b *JSONBucket) ListKeys(ctx context.Context) ([]string, error) {
 	b.m.RLock()
 	defer b.m.RUnlock()
 
+	now := time.Now()
+
 	keys := make([]string, 0, len(b.Data))
 	for k := range b.Data {
-		keys = append(keys, k)
+		if b.exists(k, now) {
+			keys = append(keys, k)
+		}
 	}
 
 	return keys, nil
 }
 
 func (b *JSONBucket) WatchAll(ctx context.Context) (Watcher, error) {
+	return b.Watch(ctx, ">")
+}
+
+// Watch watches the keys matching pattern. Only the latest revision of every key is kept, so watchers
+// starting from a revision get the current values changed since then, in order of revision, but not the
+// intermediate values and deletes.
+func (b *JSONBucket) Watch(ctx context.Context, pattern string, opts ...WatchOption) (Watcher, error) {
+	o := newWatchOptions(opts)
+
 	b.m.Lock()
-	w := &JSONWatcher{
-		bucket:  b,
-		changes: make(chan *Value, len(b.Data)+1),
-	}
+	defer b.m.Unlock()
 
-	w.m.Lock()
-	b.watchers = append(b.watchers, w)
+	var replay []*Value
+	if o.FromRevision > 0 || !o.UpdatesOnly {
+		now := time.Now()
 
-	for k, v := range b.Data {
-		v_ := &Value{Key: k, Value: v, Operation: Put}
+		for k, v := range b.Data {
+			if !b.exists(k, now) || !subject.Match(pattern, k) || b.Revisions[k] < o.FromRevision {
+				continue
+			}
 
-		w.changes <- v_
-	}
+			replay = append(replay, &Value{Key: k, Value: v, Operation: Put, Revision: b.Revisions[k]})
+		}
 
-	w.changes <- nil
+		slices.SortFunc(replay, func(a, b *Value) int {
+			return cmp.Compare(a.Revision, b.Revision)
+		})
 
-	w.m.Unlock()
+		replay = append(replay, nil)
+	}
 
-	b.m.Unlock()
+	w := newQueueWatcher(b, pattern, b.watcherOpts, replay)
+	w.after = b.Revision
+	b.watchers.add(w)
 
 	return w, nil
 }
 
+// Unwatch stops the watcher. It may be called concurrently with writes: the watcher is closed first, which
+// releases a writer blocked on it, before it is removed from the bucket.
 func (b *JSONBucket) Unwatch(w Watcher) {
-	w_, ok := w.(*JSONWatcher)
+	w_, ok := w.(*QueueWatcher)
 	if !ok {
 		return
 	}
 
-	b.m.Lock()
-	defer b.m.Unlock()
-
-	b.watchers = slices.DeleteFunc(b.watchers, func(w2 *JSONWatcher) bool {
-		return w_.changes == w2.changes
-	})
-}
-
-var ErrKeyNotFound = errors.New("key not found")
-
-var _ Watcher = &JSONWatcher{}
-
-type JSONWatcher struct {
-	bucket  *JSONBucket
-	changes chan *Value
-	m       sync.Mutex
+	b.watchers.remove(w_)
 }
 
-func (j *JSONWatcher) Changes() <-chan *Value {
-	return j.changes
-}
-
-func (j *JSONWatcher) Unwatch() {
-	j.m.Lock()
-	defer j.m.Unlock()
-
-	close(j.changes)
-	j.bucket.Unwatch(j)
-}
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyExists is returned by Create if the key already exists.
+	ErrKeyExists = errors.New("key exists")
+	// ErrRevisionMismatch is returned by Update if the key was changed since the expected revision.
+	ErrRevisionMismatch = errors.New("revision mismatch")
+	// ErrValueTooLarge is returned when a value exceeds the maximum value size of the bucket.
+	ErrValueTooLarge = errors.New("value exceeds the maximum size of the bucket")
+	// ErrTTLNotSupported is returned by SetWithTTL if the backend cannot expire the key after the given TTL.
+	ErrTTLNotSupported = errors.New("TTL is not supported by the bucket")
+)
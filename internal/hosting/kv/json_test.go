@@ -2,7 +2,14 @@ package kv
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/storage"
 )
@@ -14,8 +21,10 @@ func TestJSONKV(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() { _ = k.Close(ctx) })
 
 	testKV(ctx, t, k)
+	testKVBucketOptions(ctx, t, k)
 }
 
 func TestJSONKVWatch(t *testing.T) {
@@ -27,6 +36,7 @@ func TestJSONKVWatch(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() { _ = k.Close(ctx) })
 
 	k = WithLogger(k)
 
@@ -43,6 +53,7 @@ func TestJSONKVResumability(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+		t.Cleanup(func() { _ = k.Close(ctx) })
 
 		bucket1, err := k.Bucket(ctx, "test")
 		if err != nil {
@@ -59,6 +70,7 @@ func TestJSONKVResumability(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+		t.Cleanup(func() { _ = k.Close(ctx) })
 
 		bucket1, err := k.Bucket(ctx, "test")
 		if err != nil {
@@ -73,5 +85,400 @@ func TestJSONKVResumability(t *testing.T) {
 		if string(v) != "test" {
 			t.Fatalf("expected value to be 'test', got '%s'", string(v))
 		}
+
+		entry, err := bucket1.GetEntry(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := bucket1.Update(ctx, "test", []byte("test2"), entry.Revision); err != nil {
+			t.Fatalf("expected the revision to be restored, got %v", err)
+		}
+	}
+}
+
+func TestJSONKVExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	ExpiryInterval = 10 * time.Millisecond
+
+	k, err := NewJSONClient(storage.NewMemory(), "test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(ctx)
+
+	b, err := k.Bucket(ctx, "expiry", WithMaxValueSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Set(ctx, "large", []byte("too large value")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+
+	if err := b.Set(ctx, "forever", []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.SetWithTTL(ctx, "short", []byte("test"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher, err := b.WatchAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Unwatch()
+
+	for msg := range watcher.Changes() {
+		if msg != nil && msg.Operation == Delete {
+			if msg.Key != "short" {
+				t.Fatalf("expected key 'short' to expire, got '%s'", msg.Key)
+			}
+
+			break
+		}
+	}
+
+	keys, err := b.ListKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys[0] != "forever" {
+		t.Fatalf("expected only key 'forever' to be left, got %v", keys)
+	}
+}
+
+func newTestJSONWatcher(t *testing.T, opts WatcherOptions) (Bucket, Watcher) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	k, err := NewJSONClient(storage.NewMemory(), "test.json", WithWatcherOptions(opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = k.Close(ctx) })
+
+	b, err := k.Bucket(ctx, "watchers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher, err := b.Watch(ctx, ">", UpdatesOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, watcher
+}
+
+// setMany sets the keys 0 to n-1, failing the test if the writes do not finish in time.
+func setMany(t *testing.T, b Bucket, n int) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() {
+		for i := range n {
+			if err := b.Set(context.Background(), strconv.Itoa(i), []byte(strconv.Itoa(i))); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected writes not to be blocked by the watcher")
+	}
+}
+
+// expectClosed fails the test if the channel of watcher is not closed soon, discarding remaining changes.
+func expectClosed(t *testing.T, watcher Watcher) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-watcher.Changes():
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected the watcher to be closed")
+		}
+	}
+}
+
+func TestJSONKVWatcherOverflow(t *testing.T) {
+	t.Run("Block", func(t *testing.T) {
+		b, watcher := newTestJSONWatcher(t, WatcherOptions{
+			QueueSize:    2,
+			Overflow:     OverflowBlock,
+			BlockTimeout: 50 * time.Millisecond,
+		})
+
+		setMany(t, b, 10)
+		expectClosed(t, watcher)
+	})
+
+	t.Run("Block with consumer", func(t *testing.T) {
+		b, watcher := newTestJSONWatcher(t, WatcherOptions{
+			QueueSize:    2,
+			Overflow:     OverflowBlock,
+			BlockTimeout: 5 * time.Second,
+		})
+
+		received := make(chan []string, 1)
+		go func() {
+			var keys []string
+			for msg := range watcher.Changes() {
+				keys = append(keys, msg.Key)
+				if len(keys) == 100 {
+					break
+				}
+			}
+			received <- keys
+		}()
+
+		setMany(t, b, 100)
+
+		keys := <-received
+		for i, key := range keys {
+			if key != strconv.Itoa(i) {
+				t.Fatalf("expected change %d to be of key '%d', got '%s'", i, i, key)
+			}
+		}
+	})
+
+	t.Run("Block with consumer reading the bucket", func(t *testing.T) {
+		// The timeout outlasts setMany, so a writer holding the bucket while it waits would fail the test
+		b, watcher := newTestJSONWatcher(t, WatcherOptions{
+			QueueSize:    1,
+			Overflow:     OverflowBlock,
+			BlockTimeout: time.Minute,
+		})
+
+		failed := make(chan error, 1)
+		go func() {
+			for msg := range watcher.Changes() {
+				// Reading slower than the writer fills the queue, so the writer waits while Get is called
+				time.Sleep(time.Millisecond)
+
+				if _, err := b.Get(context.Background(), msg.Key); err != nil {
+					failed <- err
+					return
+				}
+			}
+		}()
+
+		setMany(t, b, 100)
+
+		select {
+		case err := <-failed:
+			t.Fatal(err)
+		default:
+		}
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		b, watcher := newTestJSONWatcher(t, WatcherOptions{
+			QueueSize: 2,
+			Overflow:  OverflowDropOldest,
+		})
+
+		setMany(t, b, 10)
+
+		// One change may already be on its way to the channel, the queue only holds the two newest
+		var keys []string
+		for msg := range watcher.Changes() {
+			keys = append(keys, msg.Key)
+			if msg.Key == "9" {
+				break
+			}
+		}
+
+		if len(keys) > 3 {
+			t.Fatalf("expected at most 3 changes, got %v", keys)
+		}
+
+		if keys[len(keys)-2] != "8" {
+			t.Fatalf("expected the newest changes to be kept, got %v", keys)
+		}
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		b, watcher := newTestJSONWatcher(t, WatcherOptions{
+			QueueSize: 2,
+			Overflow:  OverflowDisconnect,
+		})
+
+		setMany(t, b, 10)
+		expectClosed(t, watcher)
+	})
+}
+
+func TestJSONKVResumingWatcher(t *testing.T) {
+	ctx := context.Background()
+
+	k, err := NewJSONClient(storage.NewMemory(), "test.json", WithWatcherOptions(WatcherOptions{
+		QueueSize: 1,
+		Overflow:  OverflowDisconnect,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = k.Close(ctx) })
+
+	b, err := k.Bucket(ctx, "resuming")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setMany(t, b, 10)
+
+	watcher, err := WatchResuming(ctx, b, ">")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(watcher.Unwatch)
+
+	// The consumer does not read yet, so the watcher of the bucket is disconnected and changes are lost
+	setMany(t, b, 50)
+	for i := range 20 {
+		if err := b.Delete(ctx, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
 	}
+
+	// A consumer keeping a copy of the bucket has to end up with the keys that are left
+	want := make(map[string]bool)
+	for i := 20; i < 50; i++ {
+		want[strconv.Itoa(i)] = true
+	}
+
+	keys := make(map[string]bool)
+	synced := func() {
+		t.Helper()
+
+		timeout := time.After(5 * time.Second)
+		for !maps.Equal(keys, want) {
+			select {
+			case v, ok := <-watcher.Changes():
+				if !ok {
+					t.Fatal("expected the watcher to keep running")
+				}
+
+				if v == nil {
+					continue
+				}
+
+				if v.Operation == Put {
+					keys[v.Key] = true
+				} else {
+					delete(keys, v.Key)
+				}
+			case <-timeout:
+				t.Fatalf("expected keys %v, got %v", want, keys)
+			}
+		}
+	}
+
+	synced()
+
+	if err := b.Set(ctx, "new", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	want["new"] = true
+
+	synced()
+}
+
+func TestJSONOptions(t *testing.T) {
+	opts := JSONOptions{Watchers: DefaultWatcherOptions}
+	if err := json.Unmarshal([]byte(`{"watchers":{"overflow":"dropOldest","blockTimeout":"1s"}}`), &opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := WatcherOptions{QueueSize: DefaultWatcherOptions.QueueSize, Overflow: OverflowDropOldest, BlockTimeout: time.Second}
+	if opts.Watchers != want {
+		t.Fatalf("expected watcher options %+v, got %+v", want, opts.Watchers)
+	}
+
+	if err := json.Unmarshal([]byte(`{"watchers":{"overflow":"sometimes"}}`), &opts); err == nil {
+		t.Fatal("expected an unknown overflow policy to fail")
+	}
+}
+
+func TestJSONKVConcurrentUnwatch(t *testing.T) {
+	ctx := context.Background()
+
+	// A watcher that is never read blocks writers for the whole timeout, unless it is unwatched
+	b, blocked := newTestJSONWatcher(t, WatcherOptions{
+		QueueSize:    1,
+		Overflow:     OverflowBlock,
+		BlockTimeout: time.Minute,
+	})
+
+	var wg sync.WaitGroup
+
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range 50 {
+				if err := b.Set(ctx, fmt.Sprintf("%d.%d", i, j), []byte("test")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range 20 {
+				watcher, err := b.Watch(ctx, ">")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				go func() {
+					for range watcher.Changes() {
+					}
+				}()
+
+				watcher.Unwatch()
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	blocked.Unwatch()
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected writers to be released by Unwatch")
+	}
+
+	expectClosed(t, blocked)
 }
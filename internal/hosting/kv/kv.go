@@ -1,21 +1,140 @@
 package kv
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Client interface {
-	Bucket(ctx context.Context, name string) (Bucket, error)
+	// Bucket opens the bucket with the given name, creating it if it does not exist. If options are given,
+	// they are applied to an existing bucket as well, keeping the settings they do not set.
+	Bucket(ctx context.Context, name string, opts ...BucketOption) (Bucket, error)
+	// Close stops all watchers and releases the resources of the client.
+	Close(ctx context.Context) error
 }
 
 type Bucket interface {
 	Name() string
 	Get(ctx context.Context, key string) ([]byte, error)
+	// GetEntry returns the value of key together with its revision.
+	GetEntry(ctx context.Context, key string) (*Value, error)
 	Set(ctx context.Context, key string, value []byte) error
+	// SetWithTTL sets key so that it expires after ttl, instead of the TTL of the bucket, unless it is
+	// written again.
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Create sets key only if it does not exist yet and returns the new revision. It fails with
+	// ErrKeyExists otherwise.
+	Create(ctx context.Context, key string, value []byte) (uint64, error)
+	// Update sets key only if its current revision is expectedRevision and returns the new revision. It
+	// fails with ErrRevisionMismatch if the key was changed in the meantime.
+	Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error)
 	Delete(ctx context.Context, key string) error
+	// WatchAll watches every key of the bucket. It is the same as Watch with the pattern ">".
 	WatchAll(ctx context.Context) (Watcher, error)
+	// Watch watches the keys matching pattern. Keys are split into tokens at dots, like NATS subjects: "*"
+	// matches a single token and ">" as the last token matches one or more tokens.
+	Watch(ctx context.Context, pattern string, opts ...WatchOption) (Watcher, error)
 	Unwatch(w Watcher)
 	ListKeys(ctx context.Context) ([]string, error)
 }
 
+// BucketOptions configure a bucket. The zero value uses the defaults of the backend.
+type BucketOptions struct {
+	// TTL is how long keys are kept after they were last written. Zero keeps them forever.
+	TTL time.Duration
+	// History is the number of revisions kept per key.
+	History uint8
+	// MaxValueSize is the maximum size of a value in bytes. Zero means unlimited.
+	MaxValueSize int32
+	// Replicas is the number of replicas of the bucket in a cluster.
+	Replicas int
+	Storage  StorageType
+}
+
+type BucketOption func(*BucketOptions)
+
+func WithTTL(ttl time.Duration) BucketOption {
+	return func(o *BucketOptions) { o.TTL = ttl }
+}
+
+func WithHistory(history uint8) BucketOption {
+	return func(o *BucketOptions) { o.History = history }
+}
+
+func WithMaxValueSize(size int32) BucketOption {
+	return func(o *BucketOptions) { o.MaxValueSize = size }
+}
+
+func WithReplicas(replicas int) BucketOption {
+	return func(o *BucketOptions) { o.Replicas = replicas }
+}
+
+func WithStorage(storage StorageType) BucketOption {
+	return func(o *BucketOptions) { o.Storage = storage }
+}
+
+func newBucketOptions(opts []BucketOption) BucketOptions {
+	o := BucketOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// storedBucketOptions are the bucket options the JSON, bbolt and Redis backends keep with the bucket.
+// History, replicas and the storage type have no meaning for them.
+type storedBucketOptions struct {
+	TTL          time.Duration `json:"ttl,omitempty"`
+	MaxValueSize int32         `json:"maxValueSize,omitempty"`
+}
+
+// apply returns the options with opts applied. Settings that opts do not set are kept.
+func (o storedBucketOptions) apply(opts []BucketOption) storedBucketOptions {
+	bucketOpts := BucketOptions{TTL: o.TTL, MaxValueSize: o.MaxValueSize}
+	for _, opt := range opts {
+		opt(&bucketOpts)
+	}
+
+	return storedBucketOptions{TTL: bucketOpts.TTL, MaxValueSize: bucketOpts.MaxValueSize}
+}
+
+type StorageType int
+
+const (
+	FileStorage StorageType = iota
+	MemoryStorage
+)
+
+// WatchOptions configure a watcher. By default, a watcher replays the current value of every matching key,
+// followed by nil, before streaming changes.
+type WatchOptions struct {
+	// UpdatesOnly skips the initial replay, including the nil marker.
+	UpdatesOnly bool
+	// FromRevision replays the changes starting at this revision instead of the current values. It takes
+	// precedence over UpdatesOnly.
+	FromRevision uint64
+}
+
+type WatchOption func(*WatchOptions)
+
+func UpdatesOnly() WatchOption {
+	return func(o *WatchOptions) { o.UpdatesOnly = true }
+}
+
+func FromRevision(revision uint64) WatchOption {
+	return func(o *WatchOptions) { o.FromRevision = revision }
+}
+
+func newWatchOptions(opts []WatchOption) WatchOptions {
+	o := WatchOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
 type Watcher interface {
 	Changes() <-chan *Value
 	Unwatch()
@@ -25,6 +144,8 @@ type Value struct {
 	Key       string
 	Value     []byte
 	Operation Operation
+	// Revision increases with every change of the bucket.
+	Revision uint64
 }
 
 type Operation int
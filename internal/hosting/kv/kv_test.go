@@ -2,8 +2,10 @@ package kv
 
 import (
 	"context"
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -14,6 +16,9 @@ func testKV(ctx context.Context, t *testing.T, k Client) {
 
 	testKVCRUD(ctx, t, k)
 	testKVDoubleAccess(ctx, t, k)
+	testKVRevisions(ctx, t, k)
+	testKVTTL(ctx, t, k)
+	testKVKeyTTL(ctx, t, k)
 }
 
 func testKVCRUD(ctx context.Context, t *testing.T, k Client) {
@@ -94,9 +99,355 @@ func testKVDoubleAccess(ctx context.Context, t *testing.T, k Client) {
 	})
 }
 
+func testKVRevisions(ctx context.Context, t *testing.T, k Client) {
+	t.Run("Revisions", func(t *testing.T) {
+		b, err := k.Bucket(ctx, "revisions")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.GetEntry(ctx, "test"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+
+		created, err := b.Create(ctx, "test", []byte("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.Create(ctx, "test", []byte("test2")); !errors.Is(err, ErrKeyExists) {
+			t.Fatalf("expected ErrKeyExists, got %v", err)
+		}
+
+		entry, err := b.GetEntry(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(entry.Value) != "test" {
+			t.Fatalf("expected value to be 'test', got '%s'", string(entry.Value))
+		}
+
+		if entry.Revision != created {
+			t.Fatalf("expected revision to be %d, got %d", created, entry.Revision)
+		}
+
+		updated, err := b.Update(ctx, "test", []byte("test2"), created)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated <= created {
+			t.Fatalf("expected revision to be greater than %d, got %d", created, updated)
+		}
+
+		if _, err := b.Update(ctx, "test", []byte("test3"), created); !errors.Is(err, ErrRevisionMismatch) {
+			t.Fatalf("expected ErrRevisionMismatch, got %v", err)
+		}
+
+		v, err := b.Get(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(v) != "test2" {
+			t.Fatalf("expected value to be 'test2', got '%s'", string(v))
+		}
+
+		if err := b.Set(ctx, "test", []byte("test4")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.Update(ctx, "test", []byte("test5"), updated); !errors.Is(err, ErrRevisionMismatch) {
+			t.Fatalf("expected ErrRevisionMismatch after Set, got %v", err)
+		}
+
+		if err := b.Delete(ctx, "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.Create(ctx, "test", []byte("test6")); err != nil {
+			t.Fatalf("expected Create to succeed after Delete, got %v", err)
+		}
+	})
+}
+
+func testKVTTL(ctx context.Context, t *testing.T, k Client) {
+	t.Run("TTL", func(t *testing.T) {
+		b, err := k.Bucket(ctx, "ttl", WithTTL(time.Second), WithHistory(1), WithStorage(MemoryStorage))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.Set(ctx, "set", []byte("test")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.SetWithTTL(ctx, "set-with-ttl", []byte("test"), time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.Get(ctx, "set"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"set", "set-with-ttl"} {
+			deadline := time.Now().Add(10 * time.Second)
+
+			for {
+				_, err := b.Get(ctx, key)
+				if errors.Is(err, ErrKeyNotFound) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				if time.Now().After(deadline) {
+					t.Fatalf("expected key '%s' to expire", key)
+				}
+
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+	})
+}
+
+// testKVBucketOptions tests that opening a bucket again with some options keeps the settings that are not
+// given.
+func testKVBucketOptions(ctx context.Context, t *testing.T, k Client) {
+	t.Run("Bucket options", func(t *testing.T) {
+		if _, err := k.Bucket(ctx, "options", WithTTL(time.Second), WithMaxValueSize(256)); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := k.Bucket(ctx, "options", WithMaxValueSize(1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.Set(ctx, "large", make([]byte, 2048)); err == nil {
+			t.Fatal("expected a value above the new maximum size to be rejected")
+		}
+
+		if err := b.Set(ctx, "test", make([]byte, 512)); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			_, err := b.Get(ctx, "test")
+			if errors.Is(err, ErrKeyNotFound) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatal("expected the TTL of the bucket to be kept")
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+}
+
+func testKVKeyTTL(ctx context.Context, t *testing.T, k Client) {
+	t.Run("Key TTL", func(t *testing.T) {
+		b, err := k.Bucket(ctx, "key-ttl")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		watcher, err := b.Watch(ctx, ">", UpdatesOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer watcher.Unwatch()
+
+		if err := b.Set(ctx, "forever", []byte("test")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.SetWithTTL(ctx, "short", []byte("test"), time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		// Writing the key again without a TTL keeps it
+		if err := b.SetWithTTL(ctx, "rewritten", []byte("test"), time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.Set(ctx, "rewritten", []byte("test2")); err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			msg := receive(t, watcher)
+			if msg.Operation != Delete {
+				continue
+			}
+
+			if msg.Key != "short" {
+				t.Fatalf("expected key 'short' to expire, got '%s'", msg.Key)
+			}
+
+			break
+		}
+
+		if _, err := b.Get(ctx, "short"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound for the expired key, got %v", err)
+		}
+
+		for _, key := range []string{"forever", "rewritten"} {
+			if _, err := b.Get(ctx, key); err != nil {
+				t.Fatalf("expected key '%s' to be kept, got %v", key, err)
+			}
+		}
+	})
+}
+
 func testKVWatch(ctx context.Context, t *testing.T, k Client) {
 	testKVWatchWatch(ctx, t, k)
 	testKVWatchReplay(ctx, t, k)
+	testKVWatchPattern(ctx, t, k)
+	testKVWatchUpdatesOnly(ctx, t, k)
+	testKVWatchFromRevision(ctx, t, k)
+}
+
+// receive returns the next change of watcher, failing the test if there is none within a few seconds.
+func receive(t *testing.T, watcher Watcher) *Value {
+	t.Helper()
+
+	select {
+	case msg := <-watcher.Changes():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change, got none")
+		return nil
+	}
+}
+
+func testKVWatchPattern(ctx context.Context, t *testing.T, k Client) {
+	t.Run("Watch pattern", func(t *testing.T) {
+		b, err := k.Bucket(ctx, "watch-pattern")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"user.a", "user.b", "user.a.b", "group.a"} {
+			if err := b.Set(ctx, key, []byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		watcher, err := b.Watch(ctx, "user.*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer watcher.Unwatch()
+
+		replayed := make(map[string]bool)
+		for msg := receive(t, watcher); msg != nil; msg = receive(t, watcher) {
+			replayed[msg.Key] = true
+		}
+
+		if len(replayed) != 2 || !replayed["user.a"] || !replayed["user.b"] {
+			t.Fatalf("expected keys 'user.a' and 'user.b' to be replayed, got %v", replayed)
+		}
+
+		if err := b.Set(ctx, "group.b", []byte("group.b")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.Set(ctx, "user.c", []byte("user.c")); err != nil {
+			t.Fatal(err)
+		}
+
+		if msg := receive(t, watcher); msg == nil || msg.Key != "user.c" {
+			t.Fatalf("expected change of key 'user.c', got %v", msg)
+		}
+	})
+}
+
+func testKVWatchUpdatesOnly(ctx context.Context, t *testing.T, k Client) {
+	t.Run("Watch updates only", func(t *testing.T) {
+		b, err := k.Bucket(ctx, "watch-updates-only")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.Set(ctx, "old", []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+
+		watcher, err := b.Watch(ctx, ">", UpdatesOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer watcher.Unwatch()
+
+		if err := b.Set(ctx, "new", []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+
+		if msg := receive(t, watcher); msg == nil || msg.Key != "new" {
+			t.Fatalf("expected change of key 'new', got %v", msg)
+		}
+	})
+}
+
+func testKVWatchFromRevision(ctx context.Context, t *testing.T, k Client) {
+	t.Run("Watch from revision", func(t *testing.T) {
+		b, err := k.Bucket(ctx, "watch-from-revision")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.Create(ctx, "a", []byte("a1")); err != nil {
+			t.Fatal(err)
+		}
+
+		revision, err := b.Create(ctx, "b", []byte("b1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.Set(ctx, "a", []byte("a2")); err != nil {
+			t.Fatal(err)
+		}
+
+		watcher, err := b.Watch(ctx, ">", FromRevision(revision))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer watcher.Unwatch()
+
+		tests := []*Value{
+			{Key: "b", Value: []byte("b1"), Operation: Put},
+			{Key: "a", Value: []byte("a2"), Operation: Put},
+			nil,
+		}
+
+		for _, test := range tests {
+			msg := receive(t, watcher)
+
+			if test == nil {
+				if msg != nil {
+					t.Fatalf("expected nil, got '%s'", msg.Key)
+				}
+
+				continue
+			}
+
+			if msg == nil || msg.Key != test.Key || string(msg.Value) != string(test.Value) {
+				t.Fatalf("expected '%s' to be '%s', got %v", test.Key, string(test.Value), msg)
+			}
+
+			if msg.Revision < revision {
+				t.Fatalf("expected revision to be at least %d, got %d", revision, msg.Revision)
+			}
+		}
+	})
 }
 
 func testKVWatchWatch(ctx context.Context, t *testing.T, k Client) {
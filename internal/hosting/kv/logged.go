@@ -2,6 +2,7 @@ package kv
 
 import (
 	"context"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -17,8 +18,8 @@ func WithLogger(c Client) *Logged {
 	return &Logged{c: c}
 }
 
-func (l *Logged) Bucket(ctx context.Context, name string) (Bucket, error) {
-	b, err := l.c.Bucket(ctx, name)
+func (l *Logged) Bucket(ctx context.Context, name string, opts ...BucketOption) (Bucket, error) {
+	b, err := l.c.Bucket(ctx, name, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -29,6 +30,17 @@ func (l *Logged) Bucket(ctx context.Context, name string) (Bucket, error) {
 	}, nil
 }
 
+func (l *Logged) Close(ctx context.Context) error {
+	if err := l.c.Close(ctx); err != nil {
+		log.Debug().Err(err).Msg("Close")
+		return err
+	}
+
+	log.Debug().Msg("Close")
+
+	return nil
+}
+
 var _ Bucket = &LoggedBucket{}
 
 type LoggedBucket struct {
@@ -67,6 +79,61 @@ func (b *LoggedBucket) Set(ctx context.Context, key string, value []byte) error
 	return nil
 }
 
+func (b *LoggedBucket) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	l := b.l.With().Str("key", key).Bytes("value", value).Dur("ttl", ttl).Logger()
+
+	if err := b.b.SetWithTTL(ctx, key, value, ttl); err != nil {
+		l.Debug().Err(err).Msg("SetWithTTL")
+		return err
+	}
+
+	l.Debug().Msg("SetWithTTL")
+
+	return nil
+}
+
+func (b *LoggedBucket) GetEntry(ctx context.Context, key string) (*Value, error) {
+	l := b.l.With().Str("key", key).Logger()
+
+	v, err := b.b.GetEntry(ctx, key)
+	if err != nil {
+		l.Debug().Err(err).Msg("GetEntry")
+		return nil, err
+	}
+
+	l.Debug().Bytes("data", v.Value).Uint64("revision", v.Revision).Msg("GetEntry")
+
+	return v, nil
+}
+
+func (b *LoggedBucket) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	l := b.l.With().Str("key", key).Bytes("value", value).Logger()
+
+	revision, err := b.b.Create(ctx, key, value)
+	if err != nil {
+		l.Debug().Err(err).Msg("Create")
+		return 0, err
+	}
+
+	l.Debug().Uint64("revision", revision).Msg("Create")
+
+	return revision, nil
+}
+
+func (b *LoggedBucket) Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error) {
+	l := b.l.With().Str("key", key).Bytes("value", value).Uint64("expectedRevision", expectedRevision).Logger()
+
+	revision, err := b.b.Update(ctx, key, value, expectedRevision)
+	if err != nil {
+		l.Debug().Err(err).Msg("Update")
+		return 0, err
+	}
+
+	l.Debug().Uint64("revision", revision).Msg("Update")
+
+	return revision, nil
+}
+
 func (b *LoggedBucket) Delete(ctx context.Context, key string) error {
 	l := b.l.With().Str("key", key).Logger()
 
@@ -95,7 +162,28 @@ func (b *LoggedBucket) WatchAll(ctx context.Context) (Watcher, error) {
 	}, nil
 }
 
+func (b *LoggedBucket) Watch(ctx context.Context, pattern string, opts ...WatchOption) (Watcher, error) {
+	l := b.l.With().Str("pattern", pattern).Logger()
+
+	w, err := b.b.Watch(ctx, pattern, opts...)
+	if err != nil {
+		l.Debug().Err(err).Msg("Watch")
+		return nil, err
+	}
+
+	l.Debug().Msg("Watch")
+
+	return &LoggedWatcher{
+		w: w,
+		l: l,
+	}, nil
+}
+
 func (b *LoggedBucket) Unwatch(w Watcher) {
+	if w_, ok := w.(*LoggedWatcher); ok {
+		w = w_.w
+	}
+
 	b.b.Unwatch(w)
 
 	b.l.Debug().Msg("Unwatch")
@@ -3,9 +3,12 @@ package kv
 import (
 	"context"
 	"errors"
+	"fmt"
 	"slices"
 	"sync"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
@@ -16,36 +19,195 @@ type NATSOptions struct {
 }
 
 type NATSClient struct {
-	js jetstream.JetStream
+	js      jetstream.JetStream
+	nc      *nats.Conn
+	buckets []*NATSBucket
+	m       sync.Mutex
 }
 
+// NewNATSClient creates a client using js. The underlying connection is not closed by Close.
 func NewNATSClient(js jetstream.JetStream) *NATSClient {
 	return &NATSClient{js: js}
 }
 
-func (n *NATSClient) Bucket(ctx context.Context, name string) (Bucket, error) {
-	kv, err := n.js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: name})
+// NewNATSClientFromConn creates a client on top of nc, which is drained by Close.
+func NewNATSClientFromConn(nc *nats.Conn) (*NATSClient, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSClient{js: js, nc: nc}, nil
+}
+
+// natsLimitMarkerTTL is how long a bucket keeps the markers of expired keys, so watchers see them expire.
+// Setting it allows per-key TTLs, which requires nats-server 2.11 or later.
+// The markers count against the maximum value size, so keys of buckets with a very small one never expire.
+const natsLimitMarkerTTL = time.Minute
+
+func (n *NATSClient) Bucket(ctx context.Context, name string, opts ...BucketOption) (Bucket, error) {
+	kv, err := putKeyValue(ctx, keyValueConfig(name, newBucketOptions(opts)), n.js.CreateKeyValue)
 	if errors.Is(err, jetstream.ErrBucketExists) {
 		kv, err = n.js.KeyValue(ctx, name)
-		if err != nil {
-			return nil, err
+		if err == nil {
+			kv, err = n.updateBucket(ctx, kv, opts)
 		}
-	} else if err != nil {
+	}
+
+	if err != nil {
 		return nil, err
 	}
 
-	return &NATSBucket{
+	stream, err := streamConfig(ctx, kv)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &NATSBucket{
 		name:     name,
+		js:       n.js,
 		kv:       kv,
+		keyTTL:   stream.AllowMsgTTL,
 		watchers: make([]*NATSWatcher, 0),
-	}, nil
+	}
+
+	n.m.Lock()
+	n.buckets = append(n.buckets, b)
+	n.m.Unlock()
+
+	return b, nil
+}
+
+// updateBucket applies opts to an existing bucket and allows per-key TTLs if it does not yet. Everything
+// the options do not set is kept as configured.
+func (n *NATSClient) updateBucket(ctx context.Context, kv jetstream.KeyValue, opts []BucketOption) (jetstream.KeyValue, error) {
+	stream, err := streamConfig(ctx, kv)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opts) == 0 && stream.AllowMsgTTL {
+		return kv, nil
+	}
+
+	o := BucketOptions{
+		TTL:          stream.MaxAge,
+		History:      uint8(stream.MaxMsgsPerSubject),
+		MaxValueSize: stream.MaxMsgSize,
+		Replicas:     stream.Replicas,
+	}
+
+	if stream.Storage == jetstream.MemoryStorage {
+		o.Storage = MemoryStorage
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg := keyValueConfig(kv.Bucket(), o)
+	cfg.Description = stream.Description
+	cfg.MaxBytes = stream.MaxBytes
+	cfg.Placement = stream.Placement
+	cfg.RePublish = stream.RePublish
+	cfg.Mirror = stream.Mirror
+	cfg.Sources = stream.Sources
+	cfg.Compression = stream.Compression != jetstream.NoCompression
+
+	if stream.SubjectDeleteMarkerTTL > 0 {
+		cfg.LimitMarkerTTL = stream.SubjectDeleteMarkerTTL
+	}
+
+	return putKeyValue(ctx, cfg, n.js.UpdateKeyValue)
+}
+
+// putKeyValue creates or updates a bucket with put. Servers that do not support per-key TTLs get the bucket
+// without them.
+func putKeyValue(
+	ctx context.Context,
+	cfg jetstream.KeyValueConfig,
+	put func(context.Context, jetstream.KeyValueConfig) (jetstream.KeyValue, error),
+) (jetstream.KeyValue, error) {
+	kv, err := put(ctx, cfg)
+	if errors.Is(err, jetstream.ErrLimitMarkerTTLNotSupported) {
+		cfg.LimitMarkerTTL = 0
+		kv, err = put(ctx, cfg)
+	}
+
+	return kv, err
+}
+
+// streamConfig returns the configuration of the stream backing kv.
+func streamConfig(ctx context.Context, kv jetstream.KeyValue) (jetstream.StreamConfig, error) {
+	status, err := kv.Status(ctx)
+	if err != nil {
+		return jetstream.StreamConfig{}, err
+	}
+
+	bucketStatus, ok := status.(*jetstream.KeyValueBucketStatus)
+	if !ok {
+		return jetstream.StreamConfig{}, fmt.Errorf("unexpected status of bucket %s", kv.Bucket())
+	}
+
+	return bucketStatus.StreamInfo().Config, nil
+}
+
+func keyValueConfig(name string, o BucketOptions) jetstream.KeyValueConfig {
+	cfg := jetstream.KeyValueConfig{
+		Bucket:         name,
+		TTL:            o.TTL,
+		History:        o.History,
+		MaxValueSize:   o.MaxValueSize,
+		Replicas:       o.Replicas,
+		LimitMarkerTTL: natsLimitMarkerTTL,
+	}
+
+	if o.Storage == MemoryStorage {
+		cfg.Storage = jetstream.MemoryStorage
+	}
+
+	return cfg
+}
+
+func (n *NATSClient) Close(ctx context.Context) error {
+	n.m.Lock()
+	buckets := n.buckets
+	n.buckets = nil
+	n.m.Unlock()
+
+	for _, b := range buckets {
+		b.unwatchAll()
+	}
+
+	if n.nc == nil {
+		return nil
+	}
+
+	closed := make(chan struct{})
+	n.nc.SetClosedHandler(func(_ *nats.Conn) { close(closed) })
+
+	if err := n.nc.Drain(); err != nil {
+		n.nc.Close()
+		return err
+	}
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		n.nc.Close()
+		return ctx.Err()
+	}
 }
 
 var _ Bucket = &NATSBucket{}
 
 type NATSBucket struct {
-	name     string
-	kv       jetstream.KeyValue
+	name string
+	js   jetstream.JetStream
+	kv   jetstream.KeyValue
+	// keyTTL is set if the bucket allows per-key TTLs.
+	keyTTL   bool
 	watchers []*NATSWatcher
 	m        sync.RWMutex
 }
@@ -65,6 +227,17 @@ func (b *NATSBucket) Get(ctx context.Context, key string) ([]byte, error) {
 	return k.Value(), nil
 }
 
+func (b *NATSBucket) GetEntry(ctx context.Context, key string) (*Value, error) {
+	k, err := b.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &Value{Key: k.Key(), Value: k.Value(), Operation: Put, Revision: k.Revision()}, nil
+}
+
 func (b *NATSBucket) Set(ctx context.Context, key string, value []byte) error {
 	_, err := b.kv.Put(ctx, key, value)
 	if err != nil {
@@ -74,6 +247,44 @@ func (b *NATSBucket) Set(ctx context.Context, key string, value []byte) error {
 	return nil
 }
 
+// SetWithTTL sets key with a per-message TTL. It fails with ErrTTLNotSupported if the server is older than
+// nats-server 2.11, which expires keys only by the TTL of the bucket.
+func (b *NATSBucket) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !b.keyTTL {
+		return ErrTTLNotSupported
+	}
+
+	// KeyValue.Put takes no options, so the TTL is set by publishing to the subject of the key directly
+	if _, err := b.js.Publish(ctx, "$KV."+b.name+"."+key, value, jetstream.WithMsgTTL(ttl)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *NATSBucket) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	revision, err := b.kv.Create(ctx, key, value)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return 0, ErrKeyExists
+	} else if err != nil {
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+func (b *NATSBucket) Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error) {
+	revision, err := b.kv.Update(ctx, key, value, expectedRevision)
+	// JetStream reports a wrong last sequence the same way as an existing key
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return 0, ErrRevisionMismatch
+	} else if err != nil {
+		return 0, err
+	}
+
+	return revision, nil
+}
+
 func (b *NATSBucket) Delete(ctx context.Context, key string) error {
 	if err := b.kv.Delete(ctx, key); err != nil {
 		return err
@@ -97,7 +308,21 @@ func (b *NATSBucket) ListKeys(ctx context.Context) ([]string, error) {
 }
 
 func (b *NATSBucket) WatchAll(ctx context.Context) (Watcher, error) {
-	watcher, err := b.kv.WatchAll(ctx)
+	return b.Watch(ctx, ">")
+}
+
+func (b *NATSBucket) Watch(ctx context.Context, pattern string, opts ...WatchOption) (Watcher, error) {
+	o := newWatchOptions(opts)
+
+	var watchOpts []jetstream.WatchOpt
+	if o.FromRevision > 0 {
+		// Resuming delivers every change since the revision, which requires the history to be included
+		watchOpts = append(watchOpts, jetstream.IncludeHistory(), jetstream.ResumeFromRevision(o.FromRevision))
+	} else if o.UpdatesOnly {
+		watchOpts = append(watchOpts, jetstream.UpdatesOnly())
+	}
+
+	watcher, err := b.kv.Watch(ctx, pattern, watchOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -106,36 +331,10 @@ func (b *NATSBucket) WatchAll(ctx context.Context) (Watcher, error) {
 		bucket:  b,
 		w:       watcher,
 		changes: make(chan *Value),
+		done:    make(chan struct{}),
 	}
 
-	go func() {
-		for msg := range watcher.Updates() {
-			if msg == nil {
-				w.m.Lock()
-				w.changes <- nil
-				w.m.Unlock()
-				continue
-			}
-
-			var op Operation
-			switch msg.Operation() {
-			case jetstream.KeyValueDelete:
-				op = Delete
-			case jetstream.KeyValuePut:
-				op = Put
-			case jetstream.KeyValuePurge:
-				continue
-			}
-
-			w.m.Lock()
-			w.changes <- &Value{
-				Key:       msg.Key(),
-				Value:     msg.Value(),
-				Operation: op,
-			}
-			w.m.Unlock()
-		}
-	}()
+	go w.run()
 
 	b.m.Lock()
 	b.watchers = append(b.watchers, w)
@@ -154,9 +353,20 @@ func (b *NATSBucket) Unwatch(w Watcher) {
 	b.watchers = slices.DeleteFunc(b.watchers, func(w2 *NATSWatcher) bool {
 		return w_.w == w2.w
 	})
+	b.m.Unlock()
 
-	_ = w_.w.Stop()
+	w_.stop()
+}
+
+func (b *NATSBucket) unwatchAll() {
+	b.m.Lock()
+	watchers := b.watchers
+	b.watchers = nil
 	b.m.Unlock()
+
+	for _, w := range watchers {
+		w.stop()
+	}
 }
 
 var _ Watcher = &NATSWatcher{}
@@ -165,7 +375,48 @@ type NATSWatcher struct {
 	bucket  *NATSBucket
 	w       jetstream.KeyWatcher
 	changes chan *Value
-	m       sync.Mutex
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (w *NATSWatcher) run() {
+	defer close(w.changes)
+
+	for msg := range w.w.Updates() {
+		var v *Value
+		if msg != nil {
+			var op Operation
+			switch msg.Operation() {
+			case jetstream.KeyValueDelete:
+				op = Delete
+			case jetstream.KeyValuePut:
+				op = Put
+			case jetstream.KeyValuePurge:
+				// The markers of expired keys are reported as purges
+				op = Delete
+			}
+
+			v = &Value{
+				Key:       msg.Key(),
+				Value:     msg.Value(),
+				Operation: op,
+				Revision:  msg.Revision(),
+			}
+		}
+
+		select {
+		case w.changes <- v:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *NATSWatcher) stop() {
+	w.once.Do(func() {
+		close(w.done)
+		_ = w.w.Stop()
+	})
 }
 
 func (w *NATSWatcher) Changes() <-chan *Value {
@@ -173,9 +424,5 @@ func (w *NATSWatcher) Changes() <-chan *Value {
 }
 
 func (w *NATSWatcher) Unwatch() {
-	w.m.Lock()
-	defer w.m.Unlock()
-
-	close(w.changes)
 	w.bucket.Unwatch(w)
 }
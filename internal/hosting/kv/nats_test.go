@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	natsserver "github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
@@ -23,8 +24,10 @@ func TestNATSKV(t *testing.T) {
 
 	js := testConnectToNATS(s.ClientURL())
 	k := NewNATSClient(js)
+	t.Cleanup(func() { _ = k.Close(ctx) })
 
 	testKV(ctx, t, k)
+	testKVBucketOptions(ctx, t, k)
 }
 
 func TestNATSKVWatch(t *testing.T) {
@@ -36,6 +39,7 @@ func TestNATSKVWatch(t *testing.T) {
 
 	js := testConnectToNATS(s.ClientURL())
 	k := WithLogger(NewNATSClient(js))
+	t.Cleanup(func() { _ = k.Close(ctx) })
 
 	testKVWatch(ctx, t, k)
 }
@@ -50,6 +54,7 @@ func TestNATSKVResumability(t *testing.T) {
 	{
 		js := testConnectToNATS(s.ClientURL())
 		k := NewNATSClient(js)
+		t.Cleanup(func() { _ = k.Close(ctx) })
 
 		bucket1, err := k.Bucket(ctx, "test")
 		if err != nil {
@@ -64,6 +69,7 @@ func TestNATSKVResumability(t *testing.T) {
 	{
 		js := testConnectToNATS(s.ClientURL())
 		k := NewNATSClient(js)
+		t.Cleanup(func() { _ = k.Close(ctx) })
 
 		bucket1, err := k.Bucket(ctx, "test")
 		if err != nil {
@@ -81,6 +87,45 @@ func TestNATSKVResumability(t *testing.T) {
 	}
 }
 
+func TestNATSKVBucketOptions(t *testing.T) {
+	ctx := context.Background()
+
+	s := runServerOnPort(testPort)
+	s.Start()
+	defer s.Shutdown()
+
+	js := testConnectToNATS(s.ClientURL())
+	k := NewNATSClient(js)
+	t.Cleanup(func() { _ = k.Close(ctx) })
+
+	if _, err := k.Bucket(ctx, "options", WithTTL(time.Minute), WithHistory(5), WithMaxValueSize(64)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := k.Bucket(ctx, "options", WithTTL(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := js.KeyValue(ctx, "options")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := kv.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := status.(*jetstream.KeyValueBucketStatus).StreamInfo().Config
+	if cfg.MaxAge != time.Hour {
+		t.Fatalf("expected TTL to be updated to %s, got %s", time.Hour, cfg.MaxAge)
+	}
+
+	if cfg.MaxMsgsPerSubject != 5 || cfg.MaxMsgSize != 64 {
+		t.Fatalf("expected history 5 and max value size 64 to be kept, got %d and %d", cfg.MaxMsgsPerSubject, cfg.MaxMsgSize)
+	}
+}
+
 func runServerOnPort(port int) *natsserver.Server {
 	tmp, err := os.MkdirTemp("", "nats")
 	if err != nil {
@@ -0,0 +1,366 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/subject"
+	"github.com/rs/zerolog/log"
+)
+
+// OverflowPolicy decides what happens to a change for a queue watcher whose queue is full.
+// Disconnected watchers lose the changes since, so consumers keeping a copy of a bucket watch it with
+// WatchResuming.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the writer wait for the watcher to catch up. If it does not within the block
+	// timeout, the watcher is disconnected. The bucket is not locked meanwhile, but other writers wait for
+	// their changes to be delivered as well, to keep the order of changes.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued change to make room for the new one.
+	OverflowDropOldest
+	// OverflowDisconnect disconnects the watcher, closing its channel.
+	OverflowDisconnect
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "Block"
+	case OverflowDropOldest:
+		return "DropOldest"
+	case OverflowDisconnect:
+		return "Disconnect"
+	default:
+		return "Unknown"
+	}
+}
+
+// UnmarshalText parses the name of a policy, like "dropOldest", ignoring case.
+func (p *OverflowPolicy) UnmarshalText(text []byte) error {
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowDisconnect} {
+		if strings.EqualFold(string(text), policy.String()) {
+			*p = policy
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown overflow policy: %s", text)
+}
+
+// WatcherOptions configure how changes are delivered to the watchers of the backends that deliver changes
+// themselves, like JSON, bbolt and Redis.
+type WatcherOptions struct {
+	// QueueSize is the number of changes queued per watcher before the overflow policy applies. The
+	// initial replay is always queued in full. Zero means unbounded.
+	QueueSize    int
+	Overflow     OverflowPolicy
+	BlockTimeout time.Duration
+}
+
+// UnmarshalJSON parses options like {"queueSize": 1024, "overflow": "block", "blockTimeout": "5s"}. Fields
+// that are not set keep their current value.
+func (o *WatcherOptions) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		QueueSize    int            `json:"queueSize"`
+		Overflow     OverflowPolicy `json:"overflow"`
+		BlockTimeout string         `json:"blockTimeout"`
+	}{
+		QueueSize:    o.QueueSize,
+		Overflow:     o.Overflow,
+		BlockTimeout: o.BlockTimeout.String(),
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	blockTimeout, err := time.ParseDuration(raw.BlockTimeout)
+	if err != nil {
+		return err
+	}
+
+	*o = WatcherOptions{QueueSize: raw.QueueSize, Overflow: raw.Overflow, BlockTimeout: blockTimeout}
+
+	return nil
+}
+
+// DefaultWatcherOptions are used unless configured otherwise.
+var DefaultWatcherOptions = WatcherOptions{
+	QueueSize:    1024,
+	Overflow:     OverflowBlock,
+	BlockTimeout: 5 * time.Second,
+}
+
+type clientOptions struct {
+	watcher WatcherOptions
+}
+
+// ClientOption configures the JSON, bbolt and Redis clients.
+type ClientOption func(*clientOptions)
+
+func WithWatcherOptions(opts WatcherOptions) ClientOption {
+	return func(o *clientOptions) { o.watcher = opts }
+}
+
+func newClientOptions(opts []ClientOption) clientOptions {
+	o := clientOptions{watcher: DefaultWatcherOptions}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+var _ Watcher = &QueueWatcher{}
+
+// QueueWatcher queues the changes of its bucket and delivers them from its own goroutine, so writers never
+// wait for a slow consumer unless the overflow policy says so.
+type QueueWatcher struct {
+	bucket  Bucket
+	pattern string
+	opts    WatcherOptions
+	changes chan *Value
+	queue   []*Value
+	closed  bool
+	// after is the revision the watcher was replayed up to. Changes up to it are not delivered again, as
+	// they may still be delivered when the watcher is added.
+	after uint64
+	m     sync.Mutex
+	// queued is signalled when a change was queued, dequeued when one was delivered.
+	queued   chan struct{}
+	dequeued chan struct{}
+	done     chan struct{}
+}
+
+func newQueueWatcher(b Bucket, pattern string, opts WatcherOptions, replay []*Value) *QueueWatcher {
+	w := &QueueWatcher{
+		bucket:   b,
+		pattern:  pattern,
+		opts:     opts,
+		changes:  make(chan *Value),
+		queue:    replay,
+		queued:   make(chan struct{}, 1),
+		dequeued: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// run delivers the queued changes until the watcher is closed.
+func (w *QueueWatcher) run() {
+	defer close(w.changes)
+
+	for {
+		w.m.Lock()
+		if w.closed {
+			w.m.Unlock()
+			return
+		}
+
+		if len(w.queue) == 0 {
+			w.m.Unlock()
+
+			select {
+			case <-w.queued:
+			case <-w.done:
+				return
+			}
+
+			continue
+		}
+
+		v := w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		w.m.Unlock()
+
+		signal(w.dequeued)
+
+		select {
+		case w.changes <- v:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// enqueue queues a change and reports whether the watcher is still connected.
+func (w *QueueWatcher) enqueue(v *Value) bool {
+	var timeout <-chan time.Time
+
+	w.m.Lock()
+	for !w.closed && w.opts.QueueSize > 0 && len(w.queue) >= w.opts.QueueSize {
+		switch w.opts.Overflow {
+		case OverflowDropOldest:
+			w.queue[0] = nil
+			w.queue = w.queue[1:]
+
+		case OverflowDisconnect:
+			w.m.Unlock()
+			log.Warn().Str("bucket", w.bucket.Name()).Msgf("Disconnecting watcher of %s, its queue is full", w.pattern)
+			w.close()
+			return false
+
+		default:
+			w.m.Unlock()
+
+			if timeout == nil {
+				timer := time.NewTimer(w.opts.BlockTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+
+			select {
+			case <-w.dequeued:
+			case <-w.done:
+			case <-timeout:
+				log.Warn().Str("bucket", w.bucket.Name()).Msgf("Disconnecting watcher of %s, it did not catch up within %s", w.pattern, w.opts.BlockTimeout)
+				w.close()
+				return false
+			}
+
+			w.m.Lock()
+		}
+	}
+
+	if w.closed {
+		w.m.Unlock()
+		return false
+	}
+
+	w.queue = append(w.queue, v)
+	w.m.Unlock()
+
+	signal(w.queued)
+
+	return true
+}
+
+func (w *QueueWatcher) Changes() <-chan *Value {
+	return w.changes
+}
+
+func (w *QueueWatcher) Unwatch() {
+	w.bucket.Unwatch(w)
+}
+
+// close stops delivering changes and closes the channel once the delivering goroutine returned. Queued
+// changes are dropped.
+func (w *QueueWatcher) close() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	w.closed = true
+	w.queue = nil
+	close(w.done)
+}
+
+// queueWatchers are the queue watchers of a bucket. Changes are recorded while the bucket is locked and
+// delivered once it was unlocked, so a watcher the delivery waits for neither blocks the bucket nor
+// deadlocks a consumer using the bucket while handling a change.
+type queueWatchers struct {
+	// m guards watchers and pending. It is never held while delivering.
+	m        sync.Mutex
+	watchers []*QueueWatcher
+	pending  []*Value
+	// delivering serializes the deliveries, so the watchers get the changes in the order they were recorded.
+	delivering sync.Mutex
+}
+
+// add adds w. Changes up to w.after are not delivered to it, as they were recorded before w was replayed.
+func (q *queueWatchers) add(w *QueueWatcher) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.watchers = append(q.watchers, w)
+}
+
+// remove closes w before removing it, which releases a delivery blocked on it.
+func (q *queueWatchers) remove(w *QueueWatcher) {
+	w.close()
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.watchers = slices.DeleteFunc(q.watchers, func(w2 *QueueWatcher) bool {
+		return w == w2
+	})
+}
+
+// closeAll closes and removes all watchers.
+func (q *queueWatchers) closeAll() {
+	q.m.Lock()
+	watchers := q.watchers
+	q.watchers = nil
+	q.pending = nil
+	q.m.Unlock()
+
+	for _, w := range watchers {
+		w.close()
+	}
+}
+
+// record records a change to be delivered by the next call to deliver. Changes have to be recorded in
+// order of revision, so the bucket must be locked.
+func (q *queueWatchers) record(v *Value) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.pending = append(q.pending, v)
+}
+
+// deliver queues the recorded changes for the watchers of their keys. The bucket must not be locked.
+func (q *queueWatchers) deliver() {
+	q.delivering.Lock()
+	defer q.delivering.Unlock()
+
+	q.m.Lock()
+	pending := q.pending
+	q.pending = nil
+	watchers := slices.Clone(q.watchers)
+	q.m.Unlock()
+
+	disconnected := false
+	for _, v := range pending {
+		for _, w := range watchers {
+			if subject.Match(w.pattern, v.Key) && v.Revision > w.after && !w.enqueue(v) {
+				disconnected = true
+			}
+		}
+	}
+
+	if !disconnected {
+		return
+	}
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.watchers = slices.DeleteFunc(q.watchers, func(w *QueueWatcher) bool {
+		w.m.Lock()
+		defer w.m.Unlock()
+
+		return w.closed
+	})
+}
+
+// signal wakes up the receiver of c without blocking if it was already woken up.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,172 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func testRedisClient(t *testing.T, addr string) *RedisClient {
+	t.Helper()
+
+	k, err := NewRedisClient(RedisOptions{URL: "redis://" + addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func TestRedisKV(t *testing.T) {
+	ctx := context.Background()
+
+	k := testRedisClient(t, miniredis.RunT(t).Addr())
+	defer k.Close(ctx)
+
+	testKV(ctx, t, k)
+	testKVBucketOptions(ctx, t, k)
+}
+
+func TestRedisKVWatch(t *testing.T) {
+	ctx := context.Background()
+
+	k := testRedisClient(t, miniredis.RunT(t).Addr())
+	defer k.Close(ctx)
+
+	testKVWatch(ctx, t, WithLogger(k))
+}
+
+func TestRedisKVResumability(t *testing.T) {
+	ctx := context.Background()
+
+	addr := miniredis.RunT(t).Addr()
+
+	{
+		k := testRedisClient(t, addr)
+
+		bucket1, err := k.Bucket(ctx, "test", WithMaxValueSize(8))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := bucket1.Set(ctx, "test", []byte("test")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := k.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		k := testRedisClient(t, addr)
+		defer k.Close(ctx)
+
+		bucket1, err := k.Bucket(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry, err := bucket1.GetEntry(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(entry.Value) != "test" {
+			t.Fatalf("expected value to be 'test', got '%s'", string(entry.Value))
+		}
+
+		if _, err := bucket1.Update(ctx, "test", []byte("test2"), entry.Revision); err != nil {
+			t.Fatalf("expected the revision to be restored, got %v", err)
+		}
+
+		if err := bucket1.Set(ctx, "test", []byte("too large value")); !errors.Is(err, ErrValueTooLarge) {
+			t.Fatalf("expected the bucket options to be restored, got %v", err)
+		}
+	}
+}
+
+func TestRedisKVSharedWatch(t *testing.T) {
+	ctx := context.Background()
+
+	addr := miniredis.RunT(t).Addr()
+
+	k1 := testRedisClient(t, addr)
+	defer k1.Close(ctx)
+
+	k2 := testRedisClient(t, addr)
+	defer k2.Close(ctx)
+
+	bucket1, err := k1.Bucket(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket2, err := k2.Bucket(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher, err := bucket2.WatchAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Unwatch()
+
+	if msg := receive(t, watcher); msg != nil {
+		t.Fatalf("expected the end of the replay, got %v", msg)
+	}
+
+	if err := bucket1.Set(ctx, "test", []byte("test with spaces")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bucket1.Delete(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := receive(t, watcher)
+	if msg == nil || msg.Key != "test" || msg.Operation != Put || string(msg.Value) != "test with spaces" {
+		t.Fatalf("expected the change of the other client, got %v", msg)
+	}
+
+	msg = receive(t, watcher)
+	if msg == nil || msg.Key != "test" || msg.Operation != Delete {
+		t.Fatalf("expected the delete of the other client, got %v", msg)
+	}
+}
+
+func TestDecodeRedisChange(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    *Value
+	}{
+		{"PUT 3 4 testvalue", &Value{Key: "test", Value: []byte("value"), Operation: Put, Revision: 3}},
+		{"PUT 1 5 a b c d", &Value{Key: "a b c", Value: []byte(" d"), Operation: Put, Revision: 1}},
+		{"DEL 7 4 test", &Value{Key: "test", Operation: Delete, Revision: 7}},
+		{"PUT 1 10 test", nil},
+		{"GET 1 4 test", nil},
+		{"PUT", nil},
+	}
+
+	for _, test := range tests {
+		got, err := decodeRedisChange(test.payload)
+		if test.want == nil {
+			if err == nil {
+				t.Fatalf("decodeRedisChange(%q) = %v, expected an error", test.payload, got)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("decodeRedisChange(%q) failed: %v", test.payload, err)
+		}
+
+		if got.Key != test.want.Key || string(got.Value) != string(test.want.Value) || got.Operation != test.want.Operation || got.Revision != test.want.Revision {
+			t.Fatalf("decodeRedisChange(%q) = %v, expected %v", test.payload, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package kv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var _ Watcher = &ResumingWatcher{}
+
+// ResumingWatcher watches the keys matching a pattern like a regular watcher, but watches them again when
+// the backend closes its watcher, for example because it did not catch up with the changes. Its channel
+// is only closed once it is unwatched or its context is done, so consumers keeping a copy of the bucket
+// never stop syncing.
+type ResumingWatcher struct {
+	bucket  Bucket
+	pattern string
+	changes chan *Value
+	watcher Watcher
+	closed  bool
+	m       sync.Mutex
+	done    chan struct{}
+}
+
+// WatchResuming watches the keys of b matching pattern with a ResumingWatcher. Like a regular watcher, it
+// replays the current value of every matching key, followed by nil, before streaming changes.
+func WatchResuming(ctx context.Context, b Bucket, pattern string) (*ResumingWatcher, error) {
+	watcher, err := b.Watch(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &ResumingWatcher{
+		bucket:  b,
+		pattern: pattern,
+		changes: make(chan *Value),
+		watcher: watcher,
+		done:    make(chan struct{}),
+	}
+
+	go w.run(ctx, watcher)
+
+	return w, nil
+}
+
+// run forwards the changes of watcher, and of the watchers replacing it, until the watcher is unwatched
+// or ctx is done.
+//
+// Watching from a revision does not replay deletes on every backend, so a new watcher replays the current
+// values instead. Values the consumer already got are skipped, and keys it knows but that are not replayed
+// anymore are deleted once the replay is complete.
+func (w *ResumingWatcher) run(ctx context.Context, watcher Watcher) {
+	defer close(w.changes)
+	defer w.Unwatch()
+
+	// keys are the keys the consumer knows to exist, revision the latest revision it got
+	keys := make(map[string]bool)
+	var revision uint64
+	var replayed map[string]bool
+
+	for {
+		for v := range watcher.Changes() {
+			if v == nil {
+				if replayed == nil {
+					if !w.send(ctx, nil) {
+						return
+					}
+
+					continue
+				}
+
+				for key := range keys {
+					if replayed[key] {
+						continue
+					}
+
+					delete(keys, key)
+					if !w.send(ctx, &Value{Key: key, Operation: Delete}) {
+						return
+					}
+				}
+
+				replayed = nil
+
+				continue
+			}
+
+			if replayed != nil && v.Operation == Put {
+				replayed[v.Key] = true
+			}
+
+			if replayed != nil && v.Revision <= revision {
+				continue
+			}
+
+			revision = max(revision, v.Revision)
+
+			if v.Operation == Put {
+				keys[v.Key] = true
+			} else {
+				delete(keys, v.Key)
+			}
+
+			if !w.send(ctx, v) {
+				return
+			}
+		}
+
+		watcher = w.rewatch(ctx)
+		if watcher == nil {
+			return
+		}
+
+		replayed = make(map[string]bool)
+	}
+}
+
+// rewatch watches the keys again after the previous watcher was closed, retrying until it succeeds. It
+// returns nil if the watcher was unwatched or ctx is done meanwhile.
+func (w *ResumingWatcher) rewatch(ctx context.Context) Watcher {
+	l := log.With().Str("bucket", w.bucket.Name()).Logger()
+
+	for {
+		select {
+		case <-w.done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		l.Warn().Msgf("Watcher of %s was closed, watching again", w.pattern)
+
+		watcher, err := w.bucket.Watch(ctx, w.pattern)
+		if err == nil {
+			w.m.Lock()
+			defer w.m.Unlock()
+
+			// Unwatch may have been called while watching again, it only knew the previous watcher
+			if w.closed {
+				watcher.Unwatch()
+				return nil
+			}
+
+			w.watcher = watcher
+
+			return watcher
+		}
+
+		l.Error().Err(err).Msgf("Failed to watch %s again", w.pattern)
+
+		select {
+		case <-time.After(time.Second):
+		case <-w.done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// send delivers a change to the consumer and reports whether the watcher is still running.
+func (w *ResumingWatcher) send(ctx context.Context, v *Value) bool {
+	select {
+	case w.changes <- v:
+		return true
+	case <-w.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *ResumingWatcher) Changes() <-chan *Value {
+	return w.changes
+}
+
+func (w *ResumingWatcher) Unwatch() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	w.closed = true
+	close(w.done)
+	w.watcher.Unwatch()
+}
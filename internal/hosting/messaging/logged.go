@@ -17,17 +17,42 @@ func WithLogger(m Messager) *Logged {
 	return &Logged{m: m}
 }
 
-func (m *Logged) Subscribe(topic string, handler func(Message)) error {
+func (m *Logged) Subscribe(topic string, handler func(Message)) (Subscription, error) {
 	l := log.With().Str("topic", topic).Logger()
 
 	l.Trace().Msg("Subscribe")
 
-	return m.m.Subscribe(topic, func(m Message) {
+	sub, err := m.m.Subscribe(topic, func(m Message) {
 		wm := newLoggedMessage(m)
 		wm.l.Trace().Msg("Received")
 
 		handler(wm)
 	})
+	if err != nil {
+		l.Trace().Err(err).Msg("Failed to subscribe")
+		return nil, err
+	}
+
+	return &LoggedSubscription{s: sub, l: l}, nil
+}
+
+func (m *Logged) QueueSubscribe(topic, queue string, handler func(Message)) (Subscription, error) {
+	l := log.With().Str("topic", topic).Str("queue", queue).Logger()
+
+	l.Trace().Msg("QueueSubscribe")
+
+	sub, err := m.m.QueueSubscribe(topic, queue, func(m Message) {
+		wm := newLoggedMessage(m)
+		wm.l.Trace().Msg("Received")
+
+		handler(wm)
+	})
+	if err != nil {
+		l.Trace().Err(err).Msg("Failed to subscribe")
+		return nil, err
+	}
+
+	return &LoggedSubscription{s: sub, l: l}, nil
 }
 
 func (m *Logged) Publish(ctx context.Context, topic string, message []byte) error {
@@ -43,6 +68,64 @@ func (m *Logged) Publish(ctx context.Context, topic string, message []byte) erro
 	return nil
 }
 
+func (m *Logged) Request(ctx context.Context, topic string, message []byte) (Message, error) {
+	l := log.With().Str("topic", topic).Bytes("message", message).Logger()
+
+	res, err := m.m.Request(ctx, topic, message)
+	if err != nil {
+		l.Trace().Err(err).Msg("Request failed")
+		return nil, err
+	}
+
+	l.Trace().Bytes("response", res.Data()).Msg("Requested")
+
+	return newLoggedMessage(res), nil
+}
+
+func (m *Logged) Close(ctx context.Context) error {
+	if err := m.m.Close(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to close messaging")
+		return err
+	}
+
+	log.Trace().Msg("Closed messaging")
+
+	return nil
+}
+
+var _ Subscription = &LoggedSubscription{}
+
+type LoggedSubscription struct {
+	s Subscription
+	l zerolog.Logger
+}
+
+func (s *LoggedSubscription) Topic() string {
+	return s.s.Topic()
+}
+
+func (s *LoggedSubscription) Unsubscribe() error {
+	if err := s.s.Unsubscribe(); err != nil {
+		s.l.Error().Err(err).Msg("Failed to unsubscribe")
+		return err
+	}
+
+	s.l.Trace().Msg("Unsubscribed")
+
+	return nil
+}
+
+func (s *LoggedSubscription) Drain() error {
+	if err := s.s.Drain(); err != nil {
+		s.l.Error().Err(err).Msg("Failed to drain")
+		return err
+	}
+
+	s.l.Trace().Msg("Drained")
+
+	return nil
+}
+
 var _ Message = &LoggedMessage{}
 
 type LoggedMessage struct {
@@ -0,0 +1,344 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/subject"
+)
+
+var (
+	ErrNoReply = errors.New("message has no reply subject")
+)
+
+var _ Messager = &Memory{}
+
+// Memory is an in-process Messager. Topics are matched like NATS subjects, so "*" matches a single token
+// and ">" matches all remaining tokens.
+type Memory struct {
+	subs []*memorySubscription
+	m    sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+var _ Subscription = &memorySubscription{}
+
+type memorySubscription struct {
+	parent   *Memory
+	topic    string
+	queue    string
+	handler  func(Message)
+	pending  []*MemoryMessage
+	closed   bool
+	draining bool
+	done     chan struct{}
+	cond     *sync.Cond
+	m        sync.Mutex
+}
+
+func newMemorySubscription(parent *Memory, topic, queue string, handler func(Message)) *memorySubscription {
+	s := &memorySubscription{
+		parent:  parent,
+		topic:   topic,
+		queue:   queue,
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.m)
+
+	go s.run()
+
+	return s
+}
+
+// run delivers queued messages in order without blocking publishers, like a NATS subscription does.
+func (s *memorySubscription) run() {
+	defer close(s.done)
+
+	for {
+		s.m.Lock()
+		for len(s.pending) == 0 && !s.closed && !s.draining {
+			s.cond.Wait()
+		}
+
+		if s.closed || len(s.pending) == 0 {
+			s.m.Unlock()
+			return
+		}
+
+		msg := s.pending[0]
+		s.pending = s.pending[1:]
+		s.m.Unlock()
+
+		s.handler(msg)
+	}
+}
+
+func (s *memorySubscription) deliver(msg *MemoryMessage) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed || s.draining {
+		return false
+	}
+
+	s.pending = append(s.pending, msg)
+	s.cond.Signal()
+
+	return true
+}
+
+func (s *memorySubscription) Topic() string {
+	return s.topic
+}
+
+func (s *memorySubscription) Unsubscribe() error {
+	s.parent.remove(s)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.closed = true
+	s.pending = nil
+	s.cond.Signal()
+
+	return nil
+}
+
+func (s *memorySubscription) Drain() error {
+	s.parent.remove(s)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.draining = true
+	s.cond.Signal()
+
+	return nil
+}
+
+func (n *Memory) Subscribe(topic string, handler func(Message)) (Subscription, error) {
+	return n.QueueSubscribe(topic, "", handler)
+}
+
+func (n *Memory) QueueSubscribe(topic, queue string, handler func(Message)) (Subscription, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	sub := newMemorySubscription(n, topic, queue, handler)
+	n.subs = append(n.subs, sub)
+
+	return sub, nil
+}
+
+func (n *Memory) remove(sub *memorySubscription) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	n.subs = slices.DeleteFunc(n.subs, func(s *memorySubscription) bool {
+		return s == sub
+	})
+}
+
+func (n *Memory) Close(ctx context.Context) error {
+	n.m.RLock()
+	subs := slices.Clone(n.subs)
+	n.m.RUnlock()
+
+	for _, s := range subs {
+		if err := s.Drain(); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range subs {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (n *Memory) matching(topic string) []*memorySubscription {
+	n.m.RLock()
+	defer n.m.RUnlock()
+
+	var subs []*memorySubscription
+	queues := make(map[string][]*memorySubscription)
+	for _, s := range n.subs {
+		if !subject.Match(s.topic, topic) {
+			continue
+		}
+
+		if s.queue == "" {
+			subs = append(subs, s)
+		} else {
+			queues[s.queue] = append(queues[s.queue], s)
+		}
+	}
+
+	for _, members := range queues {
+		subs = append(subs, members[rand.Intn(len(members))])
+	}
+
+	return subs
+}
+
+func (n *Memory) publish(topic string, message []byte, reply *memoryReply) int {
+	subs := n.matching(topic)
+	if reply != nil {
+		reply.remaining = len(subs)
+	}
+
+	for _, s := range subs {
+		msg := &MemoryMessage{
+			topic: topic,
+			data:  message,
+			reply: reply,
+		}
+
+		// Subscriptions that went away in the meantime count as rejections
+		if !s.deliver(msg) && reply != nil {
+			reply.reject()
+		}
+	}
+
+	return len(subs)
+}
+
+func (n *Memory) Publish(ctx context.Context, topic string, message []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	n.publish(topic, message, nil)
+
+	return nil
+}
+
+func (n *Memory) Request(ctx context.Context, topic string, message []byte) (Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+
+	reply := &memoryReply{
+		responses: make(chan []byte, 1),
+		nak:       make(chan struct{}),
+	}
+
+	if n.publish(topic, message, reply) == 0 {
+		return nil, ErrNoResponders
+	}
+
+	select {
+	case res := <-reply.responses:
+		return &MemoryMessage{topic: topic, data: res}, nil
+	case <-reply.nak:
+		return nil, ErrNoResponders
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// memoryReply collects the answer to a request. The first response wins, and the request fails early with
+// ErrNoResponders if every receiver Naks it.
+type memoryReply struct {
+	responses chan []byte
+	nak       chan struct{}
+	remaining int
+	answered  bool
+	m         sync.Mutex
+}
+
+func (r *memoryReply) respond(message []byte) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.answered {
+		return
+	}
+
+	r.answered = true
+	r.responses <- message
+}
+
+func (r *memoryReply) reject() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.remaining--
+	if r.remaining == 0 && !r.answered {
+		r.answered = true
+		close(r.nak)
+	}
+}
+
+var _ Message = &MemoryMessage{}
+
+type MemoryMessage struct {
+	topic string
+	data  []byte
+	reply *memoryReply
+}
+
+func (m *MemoryMessage) Context() context.Context {
+	return context.Background()
+}
+
+func (m *MemoryMessage) Topic() string {
+	return m.topic
+}
+
+func (m *MemoryMessage) Data() []byte {
+	return m.data
+}
+
+func (m *MemoryMessage) String() string {
+	sb := strings.Builder{}
+
+	sb.WriteString(m.topic)
+	sb.WriteString(" ")
+	if m.reply != nil {
+		sb.WriteString(" (<- request) ")
+	}
+	sb.WriteString("-> ")
+	sb.WriteString(fmt.Sprint(len(m.data)))
+	sb.WriteString(" bytes")
+
+	return sb.String()
+}
+
+func (m *MemoryMessage) Respond(message []byte) error {
+	if m.reply == nil {
+		return ErrNoReply
+	}
+
+	m.reply.respond(message)
+
+	return nil
+}
+
+func (m *MemoryMessage) Nak() error {
+	if m.reply != nil {
+		m.reply.reject()
+	}
+
+	return nil
+}
+
+func (m *MemoryMessage) Ack() error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryPublish(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	received := make(chan Message, 2)
+	sub, err := m.Subscribe("test.*", func(msg Message) { received <- msg })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, "test.a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, "other.a", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, "test.b", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"a", "b"} {
+		select {
+		case msg := <-received:
+			if string(msg.Data()) != expected {
+				t.Fatalf("expected data to be '%s', got '%s'", expected, string(msg.Data()))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message '%s'", expected)
+		}
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, "test.c", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-received:
+		t.Fatalf("expected no message after unsubscribe, got '%s'", string(msg.Data()))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryRequest(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	if _, err := m.Request(ctx, "echo", []byte("test")); !errors.Is(err, ErrNoResponders) {
+		t.Fatalf("expected ErrNoResponders without subscribers, got %v", err)
+	}
+
+	if _, err := m.Subscribe("echo", func(msg Message) {
+		if err := msg.Nak(); err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Request(ctx, "echo", []byte("test")); !errors.Is(err, ErrNoResponders) {
+		t.Fatalf("expected ErrNoResponders when every subscriber naks, got %v", err)
+	}
+
+	if _, err := m.Subscribe("echo", func(msg Message) {
+		if err := msg.Respond(append([]byte("re: "), msg.Data()...)); err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := m.Request(ctx, "echo", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(res.Data()) != "re: test" {
+		t.Fatalf("expected response to be 're: test', got '%s'", string(res.Data()))
+	}
+
+	if err := res.Respond([]byte("test")); !errors.Is(err, ErrNoReply) {
+		t.Fatalf("expected ErrNoReply when responding to a response, got %v", err)
+	}
+}
+
+func TestMemoryQueueSubscribe(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	received := make(chan string, 10)
+	for _, name := range []string{"a", "b"} {
+		if _, err := m.QueueSubscribe("test", "workers", func(msg Message) { received <- name }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := m.Subscribe("test", func(msg Message) { received <- "all" }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, "test", []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]int)
+	for range 2 {
+		select {
+		case name := <-received:
+			got[name]++
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	select {
+	case name := <-received:
+		t.Fatalf("expected message to be delivered to one queue member only, also got it on '%s'", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got["all"] != 1 || got["a"]+got["b"] != 1 {
+		t.Fatalf("expected one delivery to the queue group and one to the plain subscription, got %v", got)
+	}
+}
+
+func TestMemoryRequestTimeout(t *testing.T) {
+	m := NewMemory()
+
+	if _, err := m.Subscribe("slow", func(msg Message) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := m.Request(ctx, "slow", []byte("test")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	release := make(chan struct{})
+	handled := make(chan string, 2)
+	if _, err := m.Subscribe("test", func(msg Message) {
+		<-release
+		handled <- string(msg.Data())
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"a", "b"} {
+		if err := m.Publish(ctx, "test", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- m.Close(ctx)
+	}()
+
+	close(release)
+
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handled) != 2 {
+		t.Fatalf("expected queued messages to be handled before close returns, got %d", len(handled))
+	}
+
+	if _, err := m.Request(ctx, "test", []byte("c")); !errors.Is(err, ErrNoResponders) {
+		t.Fatalf("expected ErrNoResponders after close, got %v", err)
+	}
+}
@@ -2,11 +2,33 @@ package messaging
 
 import (
 	"context"
+	"errors"
+)
+
+var (
+	// ErrNoResponders is returned by Request when nobody is subscribed to the topic, or every receiver Naks
+	// the message.
+	ErrNoResponders = errors.New("no responders available for request")
 )
 
 type Messager interface {
-	Subscribe(topic string, handler func(msg Message)) error
+	Subscribe(topic string, handler func(msg Message)) (Subscription, error)
+	// QueueSubscribe subscribes to topic as a member of the queue group. Each message is delivered to only
+	// one member of every queue group.
+	QueueSubscribe(topic, queue string, handler func(msg Message)) (Subscription, error)
 	Publish(ctx context.Context, topic string, message []byte) error
+	// Request publishes the message and waits for the first reply until ctx is done.
+	Request(ctx context.Context, topic string, message []byte) (Message, error)
+	// Close drains all subscriptions and closes the connection, waiting until ctx is done at most.
+	Close(ctx context.Context) error
+}
+
+type Subscription interface {
+	Topic() string
+	// Unsubscribe removes the subscription immediately, dropping messages that were not handled yet.
+	Unsubscribe() error
+	// Drain removes the subscription after all messages that were already received are handled.
+	Drain() error
 }
 
 type Message interface {
@@ -2,8 +2,10 @@ package messaging
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/nats-io/nats.go"
 )
@@ -22,21 +24,101 @@ func NewNATS(nc *nats.Conn) *NATSMessager {
 	return &NATSMessager{nc: nc}
 }
 
-func (n *NATSMessager) Subscribe(topic string, handler func(Message)) error {
-	_, err := n.nc.Subscribe(topic, func(msg *nats.Msg) {
+func (n *NATSMessager) Subscribe(topic string, handler func(Message)) (Subscription, error) {
+	sub, err := n.nc.Subscribe(topic, func(msg *nats.Msg) {
 		handler(&NATSMessage{
 			m:   msg,
 			ctx: context.Background(),
 		})
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	return &NATSSubscription{sub: sub}, nil
 }
 
-func (n *NATSMessager) Publish(_ctx context.Context, topic string, message []byte) error {
+func (n *NATSMessager) QueueSubscribe(topic, queue string, handler func(Message)) (Subscription, error) {
+	sub, err := n.nc.QueueSubscribe(topic, queue, func(msg *nats.Msg) {
+		handler(&NATSMessage{
+			m:   msg,
+			ctx: context.Background(),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSSubscription{sub: sub}, nil
+}
+
+func (n *NATSMessager) Publish(ctx context.Context, topic string, message []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return n.nc.Publish(topic, message)
 }
 
+// DefaultRequestTimeout is used for requests whose context has no deadline.
+const DefaultRequestTimeout = 5 * time.Second
+
+func (n *NATSMessager) Request(ctx context.Context, topic string, message []byte) (Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+
+	msg, err := n.nc.RequestWithContext(ctx, topic, message)
+	if errors.Is(err, nats.ErrNoResponders) {
+		return nil, ErrNoResponders
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &NATSMessage{
+		m:   msg,
+		ctx: context.Background(),
+	}, nil
+}
+
+func (n *NATSMessager) Close(ctx context.Context) error {
+	closed := make(chan struct{})
+	n.nc.SetClosedHandler(func(_ *nats.Conn) { close(closed) })
+
+	if err := n.nc.Drain(); err != nil {
+		n.nc.Close()
+		return err
+	}
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		n.nc.Close()
+		return ctx.Err()
+	}
+}
+
+var _ Subscription = &NATSSubscription{}
+
+type NATSSubscription struct {
+	sub *nats.Subscription
+}
+
+func (s *NATSSubscription) Topic() string {
+	return s.sub.Subject
+}
+
+func (s *NATSSubscription) Unsubscribe() error {
+	return s.sub.Unsubscribe()
+}
+
+func (s *NATSSubscription) Drain() error {
+	return s.sub.Drain()
+}
+
 var _ Message = &NATSMessage{}
 
 type NATSMessage struct {
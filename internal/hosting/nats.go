@@ -6,7 +6,6 @@ import (
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
 	"github.com/nats-io/nats.go"
-	"github.com/nats-io/nats.go/jetstream"
 	"github.com/pkg/errors"
 )
 
@@ -19,20 +18,6 @@ func connectToNATS(url string) (*nats.Conn, error) {
 	return nc, nil
 }
 
-func connectToJetStream(url string) (jetstream.JetStream, error) {
-	nc, err := connectToNATS(url)
-	if err != nil {
-		return nil, err
-	}
-
-	js, err := jetstream.New(nc)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create JetStream")
-	}
-
-	return js, nil
-}
-
 func GetKeyFromKV(ctx context.Context, kv kv.Bucket, key string, obj any) error {
 	val, err := kv.Get(ctx, key)
 	if err != nil {
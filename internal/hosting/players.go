@@ -0,0 +1,368 @@
+package hosting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrPlayerNotOnline    = errors.New("player is not online")
+	ErrInvalidPresenceTTL = errors.New("player presence TTL must be positive")
+)
+
+// minPresenceRefreshInterval bounds how often presences are refreshed for very short TTLs.
+const minPresenceRefreshInterval = 100 * time.Millisecond
+
+// PlayerPresence is the entry of an online player in the players bucket, stored under the player's UUID.
+type PlayerPresence struct {
+	UUID     string `json:"uuid"`
+	Username string `json:"username"`
+	// Proxy is the name of the proxy pod the player is connected to.
+	Proxy string `json:"proxy"`
+	// Server is the name of the server the player is currently playing on, empty while connecting.
+	Server      string    `json:"server,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	// LastSeen is refreshed periodically by the proxy of the player, so entries of crashed proxies expire.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// IsExpired reports whether the presence was not refreshed within ttl.
+func (p PlayerPresence) IsExpired(ttl time.Duration, now time.Time) bool {
+	if p.LastSeen.IsZero() || ttl <= 0 {
+		return false
+	}
+
+	return now.Sub(p.LastSeen) > ttl
+}
+
+// PlayerRegistry keeps track of the players of the whole network and the proxy they are connected to. It
+// is a live view of the players bucket.
+type PlayerRegistry struct {
+	kv      kv.Bucket
+	info    *PodInfo
+	ttl     time.Duration
+	players map[string]PlayerPresence
+	// local holds the presences written by this proxy, which are refreshed by Heartbeat.
+	local map[string]PlayerPresence
+	m     sync.RWMutex
+	// writes serializes the changes to the presences of this proxy, so a heartbeat never writes the
+	// presence of a player that disconnected or switched servers in the meantime.
+	writes sync.Mutex
+	l      zerolog.Logger
+}
+
+// Players returns the player registry of the network, which is kept up to date until ctx is cancelled.
+func (h *Hosting) Players(ctx context.Context) (*PlayerRegistry, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.players != nil {
+		return h.players, nil
+	}
+
+	if h.playerTTL <= 0 {
+		return nil, fmt.Errorf("%w, got %s", ErrInvalidPresenceTTL, h.playerTTL)
+	}
+
+	// Presences are refreshed within the TTL, so the bucket drops those of crashed proxies by itself
+	bucket, err := h.KV().Bucket(ctx, h.Info.KVPlayersKey(), kv.WithTTL(h.playerTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &PlayerRegistry{
+		kv:      bucket,
+		info:    h.Info,
+		ttl:     h.playerTTL,
+		players: make(map[string]PlayerPresence),
+		local:   make(map[string]PlayerPresence),
+		l:       log.With().Str("bucket", bucket.Name()).Logger(),
+	}
+
+	watcher, err := kv.WatchResuming(ctx, bucket, ">")
+	if err != nil {
+		return nil, err
+	}
+
+	go r.watch(watcher)
+
+	h.players = r
+
+	return r, nil
+}
+
+func (r *PlayerRegistry) watch(watcher kv.Watcher) {
+	for key := range watcher.Changes() {
+		if key == nil {
+			continue
+		}
+
+		switch key.Operation {
+		case kv.Put:
+			presence := PlayerPresence{}
+			if err := json.Unmarshal(key.Value, &presence); err != nil {
+				r.l.Error().Err(err).Msgf("Failed to unmarshal presence of player %s", key.Key)
+				continue
+			}
+
+			r.m.Lock()
+			r.players[key.Key] = presence
+			r.m.Unlock()
+
+		case kv.Delete:
+			r.m.Lock()
+			delete(r.players, key.Key)
+			r.m.Unlock()
+		}
+	}
+}
+
+// put writes the presence of a player connected to this proxy. r.writes must be locked.
+func (r *PlayerRegistry) put(ctx context.Context, presence PlayerPresence) error {
+	presence.LastSeen = time.Now()
+
+	if err := SetKeyToKV(ctx, r.kv, presence.UUID, presence); err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	r.local[presence.UUID] = presence
+	r.players[presence.UUID] = presence
+	r.m.Unlock()
+
+	return nil
+}
+
+// Connected marks the player as connected to this proxy.
+func (r *PlayerRegistry) Connected(ctx context.Context, uuid, username string) error {
+	r.writes.Lock()
+	defer r.writes.Unlock()
+
+	return r.put(ctx, PlayerPresence{
+		UUID:        uuid,
+		Username:    username,
+		Proxy:       r.info.PodName,
+		ConnectedAt: time.Now(),
+	})
+}
+
+// SwitchedServer updates the server of a player connected to this proxy.
+func (r *PlayerRegistry) SwitchedServer(ctx context.Context, uuid, server string) error {
+	r.writes.Lock()
+	defer r.writes.Unlock()
+
+	r.m.RLock()
+	presence, exists := r.local[uuid]
+	r.m.RUnlock()
+
+	if !exists {
+		return ErrPlayerNotOnline
+	}
+
+	presence.Server = server
+
+	return r.put(ctx, presence)
+}
+
+// Disconnected removes the player from the registry, unless it already connected to another proxy.
+func (r *PlayerRegistry) Disconnected(ctx context.Context, uuid string) error {
+	r.writes.Lock()
+	defer r.writes.Unlock()
+
+	r.m.Lock()
+	delete(r.local, uuid)
+	r.m.Unlock()
+
+	proxy, err := r.Proxy(ctx, uuid)
+	if errors.Is(err, ErrPlayerNotOnline) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if proxy != r.info.PodName {
+		return nil
+	}
+
+	if err := r.kv.Delete(ctx, uuid); err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// Heartbeat refreshes the presence of every player connected to this proxy.
+func (r *PlayerRegistry) Heartbeat(ctx context.Context) error {
+	r.m.RLock()
+	uuids := make([]string, 0, len(r.local))
+	for uuid := range r.local {
+		uuids = append(uuids, uuid)
+	}
+	r.m.RUnlock()
+
+	var errs []error
+	for _, uuid := range uuids {
+		if err := r.refresh(ctx, uuid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// refresh rewrites the current presence of the player, unless it left this proxy since the heartbeat
+// started.
+func (r *PlayerRegistry) refresh(ctx context.Context, uuid string) error {
+	r.writes.Lock()
+	defer r.writes.Unlock()
+
+	r.m.RLock()
+	presence, exists := r.local[uuid]
+	r.m.RUnlock()
+
+	if !exists {
+		return nil
+	}
+
+	return r.put(ctx, presence)
+}
+
+// ExpireStale removes the players whose presence was not refreshed within the TTL, which happens when
+// their proxy crashed. It returns the UUIDs of the expired players.
+func (r *PlayerRegistry) ExpireStale(ctx context.Context) ([]string, error) {
+	now := time.Now()
+
+	var expired []string
+
+	r.m.RLock()
+	for uuid, presence := range r.players {
+		if presence.IsExpired(r.ttl, now) {
+			expired = append(expired, uuid)
+		}
+	}
+	r.m.RUnlock()
+
+	for _, uuid := range expired {
+		r.l.Warn().Msgf("Presence of player %s expired", uuid)
+
+		// Every proxy runs this sweep, so another one may have deleted the key already
+		if err := r.kv.Delete(ctx, uuid); err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+			return expired, err
+		}
+
+		r.m.Lock()
+		delete(r.players, uuid)
+		r.m.Unlock()
+	}
+
+	return expired, nil
+}
+
+// Run refreshes the presences of this proxy and expires stale ones until ctx is cancelled.
+func (r *PlayerRegistry) Run(ctx context.Context) {
+	ticker := time.NewTicker(max(r.ttl/3, minPresenceRefreshInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Heartbeat(ctx); err != nil {
+				r.l.Error().Err(err).Msg("Failed to refresh player presences")
+			}
+
+			if _, err := r.ExpireStale(ctx); err != nil {
+				r.l.Error().Err(err).Msg("Failed to expire stale player presences")
+			}
+		}
+	}
+}
+
+// Proxy returns the name of the proxy the player is connected to. It reads the bucket directly, so the
+// result is not delayed by the watcher.
+func (r *PlayerRegistry) Proxy(ctx context.Context, uuid string) (string, error) {
+	presence := PlayerPresence{}
+	if err := GetKeyFromKV(ctx, r.kv, uuid, &presence); errors.Is(errors.Unwrap(err), kv.ErrKeyNotFound) {
+		return "", ErrPlayerNotOnline
+	} else if err != nil {
+		return "", err
+	}
+
+	return presence.Proxy, nil
+}
+
+// Get returns the presence of the player with the given UUID.
+func (r *PlayerRegistry) Get(uuid string) (PlayerPresence, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	presence, exists := r.players[uuid]
+
+	return presence, exists
+}
+
+// ByUsername returns the presence of the player with the given username, ignoring case.
+func (r *PlayerRegistry) ByUsername(username string) (PlayerPresence, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	for _, presence := range r.players {
+		if strings.EqualFold(presence.Username, username) {
+			return presence, true
+		}
+	}
+
+	return PlayerPresence{}, false
+}
+
+// All returns every online player of the network, sorted by username.
+func (r *PlayerRegistry) All() []PlayerPresence {
+	return r.filter(func(PlayerPresence) bool { return true })
+}
+
+// OnProxy returns the players connected to the given proxy pod.
+func (r *PlayerRegistry) OnProxy(proxy string) []PlayerPresence {
+	return r.filter(func(p PlayerPresence) bool { return p.Proxy == proxy })
+}
+
+// OnServer returns the players playing on the given server.
+func (r *PlayerRegistry) OnServer(server string) []PlayerPresence {
+	return r.filter(func(p PlayerPresence) bool { return p.Server == server })
+}
+
+// Count returns the number of online players of the network.
+func (r *PlayerRegistry) Count() int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return len(r.players)
+}
+
+func (r *PlayerRegistry) filter(keep func(PlayerPresence) bool) []PlayerPresence {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	var players []PlayerPresence
+	for _, presence := range r.players {
+		if keep(presence) {
+			players = append(players, presence)
+		}
+	}
+
+	slices.SortFunc(players, func(a, b PlayerPresence) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+
+	return players
+}
@@ -3,6 +3,7 @@ package hosting
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -42,6 +43,15 @@ func (p PodInfo) RPCNetworkSubject() string {
 	return fmt.Sprintf("csmc.%s.%s", p.PodNamespace, p.Network)
 }
 
+// RPCProxySubject is the subject of requests sent directly to this proxy.
+func (p PodInfo) RPCProxySubject() string {
+	return p.RPCProxySubjectOf(p.PodName)
+}
+
+func (p PodInfo) RPCProxySubjectOf(podName string) string {
+	return fmt.Sprintf("%s.proxy.%s", p.RPCNetworkSubject(), podName)
+}
+
 func (p PodInfo) DebugString() string {
 	return fmt.Sprintf("PodInfo{Network: %s, PodName: %s, PodNamespace: %s}", p.Network, p.PodName, p.PodNamespace)
 }
@@ -50,6 +60,7 @@ func (p PodInfo) KVNetworkKey() string {
 	return fmt.Sprintf("csmc_%s_%s", p.PodNamespace, p.Network)
 }
 
+// csmc_<namespace>_<network>_gamemodes<Gamemode name, Gamemode>
 func (p PodInfo) KVGamemodesKey() string {
 	return fmt.Sprintf("%s_gamemodes", p.KVNetworkKey())
 }
@@ -59,8 +70,26 @@ func (p PodInfo) KVInstancesKey() string {
 	return fmt.Sprintf("%s_instances", p.KVNetworkKey())
 }
 
+// csmc_<namespace>_<network>_players<Player UUID, PlayerPresence>
+func (p PodInfo) KVPlayersKey() string {
+	return fmt.Sprintf("%s_players", p.KVNetworkKey())
+}
+
 type InstanceInfo struct {
-	Gamemode string `json:"gamemode"`
-	Address  string `json:"address"`
-	Port     int    `json:"port"`
+	Gamemode      string    `json:"gamemode"`
+	Address       string    `json:"address"`
+	Port          int       `json:"port"`
+	Weight        int       `json:"weight,omitempty"`
+	MaxPlayers    int       `json:"maxPlayers,omitempty"`
+	LastHeartbeat time.Time `json:"lastHeartbeat,omitempty"`
+}
+
+// IsExpired reports whether the last heartbeat of the instance is older than ttl. Instances that never sent
+// a heartbeat are not expired, so servers that don't report heartbeats yet keep working.
+func (i InstanceInfo) IsExpired(ttl time.Duration, now time.Time) bool {
+	if i.LastHeartbeat.IsZero() || ttl <= 0 {
+		return false
+	}
+
+	return now.Sub(i.LastHeartbeat) > ttl
 }
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/messaging"
+)
+
+type Client struct {
+	msg messaging.Messager
+}
+
+func NewClient(msg messaging.Messager) *Client {
+	return &Client{msg: msg}
+}
+
+func randomID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Request sends the request to subject and waits for its response until ctx is done.
+func (c *Client) Request(ctx context.Context, subject string, req *Request) (*Response, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	req.ID = id
+	if req.Version == 0 {
+		req.Version = Version
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.TimeoutMS = time.Until(deadline).Milliseconds()
+	}
+
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := c.msg.Request(ctx, subject, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Response{}
+	if err := json.Unmarshal(msg.Data(), res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Call sends a typed request to subject and decodes the typed response. Responses with a status other
+// than StatusOk are returned as *Error.
+func Call[Req any, Res any](ctx context.Context, c *Client, subject string, typ Type, req *Req) (*Res, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Request(ctx, subject, &Request{Type: typ, Data: string(data)})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Status != "" && res.Status != StatusOk {
+		return nil, &Error{Status: res.Status, Message: res.Error}
+	}
+
+	out := new(Res)
+	if res.Data == "" {
+		return out, nil
+	}
+
+	if err := json.Unmarshal([]byte(res.Data), out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -1,6 +1,15 @@
 package rpc
 
-import "go.minekube.com/gate/pkg/util/uuid"
+import (
+	"errors"
+	"fmt"
+
+	"go.minekube.com/gate/pkg/util/uuid"
+)
+
+// Version is the version of the RPC envelope understood by this proxy. Requests without a version are
+// treated as version 1.
+const Version = 1
 
 type Type string
 
@@ -9,13 +18,22 @@ const (
 )
 
 type Request struct {
-	Type Type   `json:"type"`
-	Data string `json:"data"`
+	Type    Type   `json:"type"`
+	Data    string `json:"data"`
+	Version int    `json:"version,omitempty"`
+	// ID correlates responses with requests.
+	ID string `json:"id,omitempty"`
+	// TimeoutMS is the time in milliseconds the caller is willing to wait for the response.
+	TimeoutMS int64 `json:"timeoutMs,omitempty"`
 }
 
 type Response struct {
-	Type Type   `json:"type"`
-	Data string `json:"data"`
+	Type    Type   `json:"type"`
+	Data    string `json:"data"`
+	Version int    `json:"version,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Status  Status `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 type TransferPlayerRequest struct {
@@ -31,6 +49,30 @@ type TransferPlayerResponse struct {
 type Status string
 
 const (
-	StatusOk    Status = "OK"
-	StatusError Status = "ERROR"
+	StatusOk                 Status = "OK"
+	StatusError              Status = "ERROR"
+	StatusBadRequest         Status = "BAD_REQUEST"
+	StatusNotFound           Status = "NOT_FOUND"
+	StatusTimeout            Status = "TIMEOUT"
+	StatusUnsupportedVersion Status = "UNSUPPORTED_VERSION"
 )
+
+var (
+	// ErrNotHandled is returned by handlers that can't handle a request, so that another subscriber can
+	// answer it instead.
+	ErrNotHandled = errors.New("request not handled")
+)
+
+// Error is an error with a status that is sent back to the caller.
+type Error struct {
+	Status  Status
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc: %s: %s", e.Status, e.Message)
+}
+
+func Errorf(status Status, format string, args ...any) *Error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,224 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/messaging"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type handler func(ctx context.Context, data []byte) (any, error)
+
+// HandlerFunc handles a decoded request of a single type.
+type HandlerFunc[Req any, Res any] func(ctx context.Context, req *Req) (*Res, error)
+
+type Server struct {
+	msg      messaging.Messager
+	timeout  time.Duration
+	handlers map[Type]handler
+	subs     []messaging.Subscription
+	m        sync.RWMutex
+	l        zerolog.Logger
+}
+
+// NewServer creates a server answering requests received through msg. Handlers get at most timeout to
+// answer, unless the caller asks for a shorter timeout.
+func NewServer(msg messaging.Messager, timeout time.Duration) *Server {
+	return &Server{
+		msg:      msg,
+		timeout:  timeout,
+		handlers: make(map[Type]handler),
+		l:        log.With().Str("component", "rpc").Logger(),
+	}
+}
+
+// Handle registers the handler for requests of the given type, replacing any previous handler.
+func Handle[Req any, Res any](s *Server, typ Type, h HandlerFunc[Req, Res]) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.handlers[typ] = func(ctx context.Context, data []byte) (any, error) {
+		req := new(Req)
+		if err := json.Unmarshal(data, req); err != nil {
+			return nil, Errorf(StatusBadRequest, "failed to unmarshal request: %v", err)
+		}
+
+		return h(ctx, req)
+	}
+}
+
+// Listen subscribes to subject and dispatches received requests to their handlers.
+func (s *Server) Listen(subject string) error {
+	sub, err := s.msg.Subscribe(subject, s.handle)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	s.subs = append(s.subs, sub)
+	s.m.Unlock()
+
+	return nil
+}
+
+// ListenQueue subscribes to subject as a member of the queue group, so that each request is handled by
+// only one server of the group.
+func (s *Server) ListenQueue(subject, queue string) error {
+	sub, err := s.msg.QueueSubscribe(subject, queue, s.handle)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	s.subs = append(s.subs, sub)
+	s.m.Unlock()
+
+	return nil
+}
+
+// Close stops listening after all requests that were already received are answered.
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var errs []error
+	for _, sub := range s.subs {
+		if err := sub.Drain(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.subs = nil
+
+	return errors.Join(errs...)
+}
+
+func (s *Server) handle(msg messaging.Message) {
+	l := s.l.With().Bytes("data", msg.Data()).Logger()
+	l.Trace().Msg("Received raw request")
+
+	req := &Request{}
+	if err := json.Unmarshal(msg.Data(), req); err != nil {
+		l.Error().Err(err).Msg("Failed to unmarshal request")
+		s.respond(msg, req, nil, Errorf(StatusBadRequest, "failed to unmarshal envelope: %v", err))
+		return
+	}
+
+	l = l.With().Str("type", string(req.Type)).Str("id", req.ID).Logger()
+
+	if req.Version > Version {
+		s.respond(msg, req, nil, Errorf(StatusUnsupportedVersion, "version %d is not supported, expected at most %d", req.Version, Version))
+		return
+	}
+
+	s.m.RLock()
+	h, exists := s.handlers[req.Type]
+	s.m.RUnlock()
+
+	// Other services may share the subject and handle this type
+	if !exists {
+		l.Trace().Msg("Ignoring request of unknown type")
+
+		if err := msg.Nak(); err != nil {
+			l.Error().Err(err).Msg("Failed to nack request")
+		}
+
+		return
+	}
+
+	timeout := s.timeout
+	if req.TimeoutMS > 0 && time.Duration(req.TimeoutMS)*time.Millisecond < timeout {
+		timeout = time.Duration(req.TimeoutMS) * time.Millisecond
+	}
+
+	ctx, cancel := context.WithTimeout(msg.Context(), timeout)
+	defer cancel()
+
+	type result struct {
+		res any
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		res, err := h(ctx, []byte(req.Data))
+		done <- result{res: res, err: err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		r = result{err: Errorf(StatusTimeout, "handler did not answer within %s", timeout)}
+	}
+
+	if errors.Is(r.err, ErrNotHandled) {
+		l.Trace().Msg("Request not handled")
+
+		if err := msg.Nak(); err != nil {
+			l.Error().Err(err).Msg("Failed to nack request")
+		}
+
+		return
+	}
+
+	s.respond(msg, req, r.res, r.err)
+}
+
+// legacyErrorData is the data of failed responses before the envelope had a status. It is still sent to
+// callers of version 1.
+const legacyErrorData = `{"status":"ERROR"}`
+
+func (s *Server) respond(msg messaging.Message, req *Request, data any, handlerErr error) {
+	l := s.l.With().Str("type", string(req.Type)).Str("id", req.ID).Logger()
+
+	res := &Response{
+		Type:    req.Type,
+		Version: Version,
+		ID:      req.ID,
+		Status:  StatusOk,
+	}
+
+	if handlerErr != nil {
+		l.Debug().Err(handlerErr).Msg("Request failed")
+
+		rpcErr := &Error{}
+		if errors.As(handlerErr, &rpcErr) {
+			res.Status = rpcErr.Status
+			res.Error = rpcErr.Message
+		} else {
+			res.Status = StatusError
+			res.Error = handlerErr.Error()
+		}
+	} else if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			l.Error().Err(err).Msg("Failed to marshal response data")
+
+			res.Status = StatusError
+			res.Error = "failed to marshal response"
+		} else {
+			res.Data = string(raw)
+		}
+	}
+
+	// Callers of version 1 may read the status from the data only, as they did before the envelope had one
+	if res.Status != StatusOk && req.Version <= 1 {
+		res.Data = legacyErrorData
+	}
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		l.Error().Err(err).Msg("Failed to marshal response")
+		return
+	}
+
+	if err := msg.Respond(raw); err != nil {
+		l.Error().Err(err).Msg("Failed to respond to request")
+	}
+}
@@ -77,3 +77,7 @@ func (f *FS) Delete(ctx context.Context, key string) error {
 
 	return nil
 }
+
+func (f *FS) Close(ctx context.Context) error {
+	return nil
+}
@@ -120,6 +120,17 @@ func (c *Logged) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+func (c *Logged) Close(ctx context.Context) error {
+	if err := c.s.Close(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to close")
+		return err
+	}
+
+	log.Trace().Msg("Closed")
+
+	return nil
+}
+
 type loggedWriteCloser struct {
 	io.WriteCloser
 	l zerolog.Logger
@@ -58,6 +58,10 @@ func (m *Memory) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+func (m *Memory) Close(ctx context.Context) error {
+	return nil
+}
+
 type writeCloser struct {
 	*bytes.Buffer
 	key string
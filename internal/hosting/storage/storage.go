@@ -12,6 +12,7 @@ type Storage interface {
 	Save(ctx context.Context, key string, content []byte) error
 	SaveStreaming(ctx context.Context, key string) (io.WriteCloser, error)
 	Delete(ctx context.Context, key string) error
+	Close(ctx context.Context) error
 }
 
 var (
@@ -0,0 +1,103 @@
+// Package favicon converts images to the favicons shown in the server list. Minecraft only accepts 64x64
+// PNG images encoded as a data URI, so other images are scaled down or up to that size.
+package favicon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// Size is the width and height of a favicon in pixels.
+const Size = 64
+
+const dataURIPrefix = "data:image/png;base64,"
+
+var (
+	ErrEmptyImage = errors.New("image has no pixels")
+	ErrNotDataURI = errors.New("favicon is not a PNG data URI")
+)
+
+// Encode decodes a PNG, JPEG or GIF image, resizes it to 64x64 if necessary and returns it as a data URI.
+func Encode(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return "", ErrEmptyImage
+	}
+
+	if bounds.Dx() != Size || bounds.Dy() != Size {
+		img = Resize(img, Size, Size)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return "", fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return dataURIPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Resize scales img to width x height. Every target pixel is the average of the source pixels it covers,
+// which keeps downscaled icons smooth, and upscaling falls back to nearest neighbour.
+func Resize(img image.Image, width, height int) *image.NRGBA {
+	src := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := max(src.Min.Y+(y+1)*src.Dy()/height, y0+1)
+
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := max(src.Min.X+(x+1)*src.Dx()/width, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					// Weigh colors by alpha, so transparent pixels don't darken the edges
+					r += uint64(c.R) * uint64(c.A)
+					g += uint64(c.G) * uint64(c.A)
+					b += uint64(c.B) * uint64(c.A)
+					a += uint64(c.A)
+					n++
+				}
+			}
+
+			if a == 0 {
+				continue
+			}
+
+			dst.Set(x, y, color.NRGBA64{
+				R: uint16(r / a),
+				G: uint16(g / a),
+				B: uint16(b / a),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// Decode returns the PNG data of a favicon data URI.
+func Decode(uri string) ([]byte, error) {
+	raw, ok := strings.CutPrefix(uri, dataURIPrefix)
+	if !ok {
+		return nil, ErrNotDataURI
+	}
+
+	return base64.StdEncoding.DecodeString(raw)
+}
@@ -0,0 +1,58 @@
+package favicon
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestEncode(t *testing.T) {
+	for _, size := range []int{16, 64, 100, 256} {
+		src := image.NewNRGBA(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				src.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			}
+		}
+
+		uri, err := Encode(encodePNG(t, src))
+		if err != nil {
+			t.Fatalf("failed to encode %dx%d image: %v", size, size, err)
+		}
+
+		data, err := Decode(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.Bounds().Dx() != Size || img.Bounds().Dy() != Size {
+			t.Fatalf("expected %dx%d image to be resized to %dx%d, got %v", size, size, Size, Size, img.Bounds())
+		}
+
+		if got := color.NRGBAModel.Convert(img.At(Size/2, Size/2)).(color.NRGBA); got != (color.NRGBA{R: 255, A: 255}) {
+			t.Fatalf("expected resized %dx%d image to stay red, got %v", size, size, got)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	if _, err := Encode([]byte("not an image")); err == nil {
+		t.Fatal("expected an error for invalid image data")
+	}
+}
@@ -30,19 +30,28 @@ func Parse(mini string) *c.Text {
 			continue
 		}
 
-		split := strings.Split(s, ">")
+		split := strings.SplitN(s, ">", 2)
+
+		// Text without a tag, like the start of the input
+		if len(split) == 1 {
+			components = append(components, &c.Text{Content: s, S: styles[len(styles)-1]})
+			continue
+		}
 
 		key := split[0]
 		if strings.HasPrefix(key, "/") {
-			styles = styles[:len(styles)-1]
+			if len(styles) > 1 {
+				styles = styles[:len(styles)-1]
+			}
 		} else {
 			newStyle := styles[len(styles)-1]
 
 			styles = append(styles, newStyle)
 		}
 
-		newText := modify(key, split[1], &styles[len(styles)-1])
-		components = append(components, newText)
+		if newText := modify(key, split[1], &styles[len(styles)-1]); newText != nil {
+			components = append(components, newText)
+		}
 
 	}
 
@@ -52,55 +61,92 @@ func Parse(mini string) *c.Text {
 }
 
 // modify takes a key, content, and style as input and returns a `c.Text` object. It modifies the style
-// based on the key and returns a new text component with the modified style and content.
+// based on the key and returns a new text component with the modified style and content. Tags that can't
+// be applied, like colors with an unknown name, keep the current style. Validate reports them.
 func modify(key string, content string, style *c.Style) *c.Text {
-	newText := &c.Text{}
+	colors, err := tagColors(key)
+	if err != nil {
+		return &c.Text{Content: content, S: *style}
+	}
 
 	switch {
-	case strings.HasPrefix(key, "#"): // <#ff00ff>
-		parsed, err := ParseColor(key)
-		if err != nil {
-			fmt.Println(err)
-			return nil
+	case isTag(key, "gradient") && len(colors) > 1: // <gradient:light_purple:gold>
+		rgbs := make([]color.RGB, len(colors))
+		for i, col := range colors {
+			newColor, _ := color.Make(col)
+			rgbs[i] = *newColor
 		}
-		style.Color = parsed
-		newText.Content = content
-		newText.S = *style
-	case strings.HasPrefix(key, "color"): // <color:light_purple>
-		colorName := strings.Split(key, ":")[1]
-		parsed, err := ParseColor(colorName)
-		if err != nil {
-			fmt.Println(err)
-			return nil
-		}
-		style.Color = parsed
-		newText.Content = content
-		newText.S = *style
+
+		return Gradient(content, *style, rgbs...)
+
+	case len(colors) > 0: // <#ff00ff>, <color:light_purple> and gradients of a single color
+		style.Color = colors[0]
 
 	case key == "bold": // <bold>
 		style.Bold = c.True
-		newText.Content = content
-		newText.S = *style
-
-	case strings.HasPrefix(key, "gradient"): // <gradient:light_purple:gold>
-		colorKey := strings.Split(key, ":")
-		colorNames := colorKey[1:]
-
-		colors := make([]color.RGB, len(colorNames))
-		for i, col := range colorNames {
-			parsedColor, err := ParseColor(col)
-			if err != nil {
-				fmt.Println(err)
-				return nil
-			}
-			newColor, _ := color.Make(parsedColor)
-			colors[i] = *newColor
+	}
+
+	// closing and unknown tags keep the current style
+	return &c.Text{Content: content, S: *style}
+}
+
+// Validate reports the first tag of mini that Parse can't apply, like a color tag without a color or with
+// an unknown one.
+func Validate(mini string) error {
+	for _, s := range strings.Split(mini, "<") {
+		split := strings.SplitN(s, ">", 2)
+		if len(split) == 1 {
+			continue
 		}
 
-		newText = Gradient(content, *style, colors...)
+		if _, err := tagColors(split[0]); err != nil {
+			return err
+		}
 	}
 
-	return newText
+	return nil
+}
+
+// tagColors returns the colors set by a color or gradient tag, or nil for other tags.
+func tagColors(key string) ([]color.Color, error) {
+	var names []string
+
+	switch {
+	case strings.HasPrefix(key, "#"):
+		names = []string{key}
+
+	case isTag(key, "color"):
+		names = strings.Split(key, ":")[1:]
+		if len(names) != 1 {
+			return nil, fmt.Errorf("tag <%s> needs exactly one color", key)
+		}
+
+	case isTag(key, "gradient"):
+		names = strings.Split(key, ":")[1:]
+		if len(names) == 0 {
+			return nil, fmt.Errorf("tag <%s> needs at least one color", key)
+		}
+
+	default:
+		return nil, nil
+	}
+
+	colors := make([]color.Color, len(names))
+	for i, name := range names {
+		parsed, err := ParseColor(name)
+		if err != nil {
+			return nil, fmt.Errorf("tag <%s>: %w", key, err)
+		}
+
+		colors[i] = parsed
+	}
+
+	return colors, nil
+}
+
+// isTag reports whether key is the tag with the given name, with or without arguments.
+func isTag(key string, name string) bool {
+	return key == name || strings.HasPrefix(key, name+":")
 }
 
 // ParseColor takes a string as input and returns a `color.Color` object. It checks if the input string
@@ -135,7 +181,12 @@ func FromName(name string) (color.Color, error) {
 
 // Gradient takes a string, a style, and a variable number of colors as input and returns a `c.Text` object.
 // It creates a gradient effect by interpolating between the input colors based on their position in the input string.
+// Without colors, the content keeps the style.
 func Gradient(content string, style c.Style, colors ...color.RGB) *c.Text {
+	if len(colors) == 0 {
+		return &c.Text{Content: content, S: style}
+	}
+
 	var component []c.Component
 	for id, i := range strings.Split(content, "") {
 		t := float64(id) / float64(len(content))
@@ -154,11 +205,11 @@ func Gradient(content string, style c.Style, colors ...color.RGB) *c.Text {
 }
 
 // LerpColor takes a float and a variable number of colors as input and returns a `color.Color` object.
-// It interpolates between the input colors based on the input float.
+// It interpolates between the input colors based on the input float. At least one color is required.
 func LerpColor(t float64, colors ...color.RGB) color.Color {
 	t = math.Min(t, 1)
 
-	if t == 1 {
+	if t == 1 || len(colors) == 1 {
 		return &colors[len(colors)-1]
 	}
 
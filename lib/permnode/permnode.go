@@ -0,0 +1,203 @@
+// Package permnode matches permission nodes like "whitelist.add" against granted nodes. Granted nodes may
+// end with a "*" wildcard matching any deeper node, use "*" for a single segment in between, or be
+// negated with a leading "-" to deny a node that would otherwise be granted. Granted nodes may be scoped
+// to a context with a suffix like "kick[gamemode=minigames]", so they only apply there.
+package permnode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Wildcard matches every node on its own and any remaining segments at the end of a node.
+const Wildcard = "*"
+
+// Negation denies the node it prefixes.
+const Negation = "-"
+
+// Context keys that granted nodes can be scoped to.
+const (
+	ContextServer   = "server"
+	ContextGamemode = "gamemode"
+	ContextProxy    = "proxy"
+)
+
+var (
+	ErrInvalidContext = errors.New("invalid permission context")
+)
+
+// Context describes where a permission is checked, like {"server": "lobby-0", "gamemode": "lobby"}.
+type Context map[string]string
+
+// Entry is a parsed granted node.
+type Entry struct {
+	Node    string
+	Negated bool
+	// Context must be matched entirely by the context of a check for the entry to apply.
+	Context Context
+}
+
+// ParseEntry parses a granted node like "-kick[gamemode=minigames,proxy=proxy-0]".
+func ParseEntry(entry string) (Entry, error) {
+	e := Entry{}
+
+	e.Node, e.Negated = strings.CutPrefix(entry, Negation)
+
+	node, scope, scoped := strings.Cut(e.Node, "[")
+	if !scoped {
+		return e, nil
+	}
+
+	scope, closed := strings.CutSuffix(scope, "]")
+	if !closed {
+		return Entry{}, fmt.Errorf("%w: %s is missing a closing bracket", ErrInvalidContext, entry)
+	}
+
+	e.Node = node
+	e.Context = make(Context)
+	for _, pair := range strings.Split(scope, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return Entry{}, fmt.Errorf("%w: %s has no key=value pair in %q", ErrInvalidContext, entry, pair)
+		}
+
+		e.Context[key] = value
+	}
+
+	return e, nil
+}
+
+// Applies reports whether the entry applies in ctx. Unscoped entries apply everywhere.
+func (e Entry) Applies(ctx Context) bool {
+	for key, value := range e.Context {
+		if !strings.EqualFold(ctx[key], value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type Result int
+
+const (
+	Undefined Result = iota
+	Allow
+	Deny
+)
+
+func (r Result) String() string {
+	switch r {
+	case Allow:
+		return "Allow"
+	case Deny:
+		return "Deny"
+	default:
+		return "Undefined"
+	}
+}
+
+// Match reports whether the granted pattern covers node. Matching ignores case, negation and context.
+func Match(pattern, node string) bool {
+	entry, err := ParseEntry(pattern)
+	if err != nil {
+		return false
+	}
+
+	_, ok := specificity(entry.Node, node)
+	return ok
+}
+
+// specificity returns how specifically pattern matches node. Exact segments weigh more than wildcards,
+// so "a.b.*" is more specific than "a.*" and "a.b" is more specific than "a.*".
+func specificity(pattern, node string) (int, bool) {
+	if pattern == "" || node == "" {
+		return 0, false
+	}
+
+	p := strings.Split(strings.ToLower(pattern), ".")
+	n := strings.Split(strings.ToLower(node), ".")
+
+	score := 0
+	for i, segment := range p {
+		last := i == len(p)-1
+
+		if segment == Wildcard && last {
+			// A trailing wildcard needs at least one segment to match, except for the global wildcard
+			if i >= len(n) && i > 0 {
+				return 0, false
+			}
+
+			return score*2 + 1, true
+		}
+
+		if i >= len(n) {
+			return 0, false
+		}
+
+		switch segment {
+		case Wildcard:
+			score++
+		case n[i]:
+			score += 2
+		default:
+			return 0, false
+		}
+	}
+
+	if len(p) != len(n) {
+		return 0, false
+	}
+
+	return score*2 + 2, true
+}
+
+// Check resolves node in ctx against a list of granted nodes. The most specific matching entry decides:
+// entries matching more of the node win first, then entries scoped to more of the context, and a negated
+// entry wins over a grant that is equally specific. Invalid entries are ignored.
+func Check(granted []string, node string, ctx Context) Result {
+	best := -1
+	result := Undefined
+
+	for _, raw := range granted {
+		entry, err := ParseEntry(raw)
+		if err != nil || !entry.Applies(ctx) {
+			continue
+		}
+
+		score, ok := specificity(entry.Node, node)
+		if !ok {
+			continue
+		}
+
+		score = score*(len(ctx)+1) + len(entry.Context)
+		if score < best {
+			continue
+		}
+
+		if score > best {
+			best = score
+			result = Allow
+		}
+
+		if entry.Negated {
+			result = Deny
+		}
+	}
+
+	return result
+}
+
+// CheckAll resolves node in ctx against several lists of granted nodes in order of precedence. The first
+// list with a defined result decides.
+func CheckAll(node string, ctx Context, sources ...[]string) Result {
+	for _, granted := range sources {
+		if result := Check(granted, node, ctx); result != Undefined {
+			return result
+		}
+	}
+
+	return Undefined
+}
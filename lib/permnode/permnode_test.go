@@ -0,0 +1,160 @@
+package permnode
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		node    string
+		match   bool
+	}{
+		{"admin", "admin", true},
+		{"admin", "admin.kick", false},
+		{"Whitelist.Add", "whitelist.add", true},
+		{"whitelist.add", "whitelist.remove", false},
+		{"whitelist.*", "whitelist.add", true},
+		{"whitelist.*", "whitelist", false},
+		{"whitelist.*", "whitelist.add.other", true},
+		{"whitelist.*", "permissions.add", false},
+		{"a.b.*", "a.b.c", true},
+		{"a.b.*", "a.b.c.d", true},
+		{"a.b.*", "a.c.d", false},
+		{"a.b.*", "a.b", false},
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a.b.d", false},
+		{"a.*.c", "a.b.c.d", false},
+		{"*", "admin", true},
+		{"*", "a.b.c", true},
+		{"-whitelist.add", "whitelist.add", true},
+		{"kick[gamemode=minigames]", "kick", true},
+		{"kick[gamemode", "kick", false},
+		{"", "admin", false},
+		{"admin", "", false},
+	}
+
+	for _, test := range tests {
+		if got := Match(test.pattern, test.node); got != test.match {
+			t.Errorf("Match(%q, %q) = %v, expected %v", test.pattern, test.node, got, test.match)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted []string
+		node    string
+		result  Result
+	}{
+		{"no nodes", nil, "admin", Undefined},
+		{"no match", []string{"whitelist.add"}, "admin", Undefined},
+		{"exact", []string{"admin"}, "admin", Allow},
+		{"wildcard", []string{"whitelist.*"}, "whitelist.add", Allow},
+		{"global wildcard", []string{"*"}, "whitelist.add", Allow},
+		{"negated exact", []string{"-admin"}, "admin", Deny},
+		{"negation overrides wildcard", []string{"whitelist.*", "-whitelist.add"}, "whitelist.add", Deny},
+		{"negation only affects its node", []string{"whitelist.*", "-whitelist.add"}, "whitelist.remove", Allow},
+		{"negation overrides global wildcard", []string{"*", "-maintenance.bypass"}, "maintenance.bypass", Deny},
+		{"exact overrides negated wildcard", []string{"-whitelist.*", "whitelist.add"}, "whitelist.add", Allow},
+		{"deeper wildcard overrides negated wildcard", []string{"-a.*", "a.b.*"}, "a.b.c", Allow},
+		{"negation wins at same specificity", []string{"admin", "-admin"}, "admin", Deny},
+		{"negation wins regardless of order", []string{"-admin", "admin"}, "admin", Deny},
+	}
+
+	for _, test := range tests {
+		if got := Check(test.granted, test.node, nil); got != test.result {
+			t.Errorf("%s: Check(%v, %q) = %v, expected %v", test.name, test.granted, test.node, got, test.result)
+		}
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources [][]string
+		node    string
+		result  Result
+	}{
+		{"no sources", nil, "admin", Undefined},
+		{"first defined source decides", [][]string{{"whitelist.add"}, {"-admin"}, {"admin"}}, "admin", Deny},
+		{"earlier grant overrides later negation", [][]string{{"admin"}, {"-admin"}}, "admin", Allow},
+		{"earlier wildcard overrides later exact negation", [][]string{{"*"}, {"-admin"}}, "admin", Allow},
+		{"all undefined", [][]string{{"whitelist.*"}, {"permissions.*"}}, "admin", Undefined},
+	}
+
+	for _, test := range tests {
+		if got := CheckAll(test.node, nil, test.sources...); got != test.result {
+			t.Errorf("%s: CheckAll(%q, %v) = %v, expected %v", test.name, test.node, test.sources, got, test.result)
+		}
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		entry string
+		node  string
+		neg   bool
+		ctx   Context
+		valid bool
+	}{
+		{"kick", "kick", false, nil, true},
+		{"-kick", "kick", true, nil, true},
+		{"kick[gamemode=minigames]", "kick", false, Context{"gamemode": "minigames"}, true},
+		{"-kick[Server=lobby-0, proxy=proxy-1]", "kick", true, Context{"server": "lobby-0", "proxy": "proxy-1"}, true},
+		{"kick[gamemode]", "", false, nil, false},
+		{"kick[gamemode=]", "", false, nil, false},
+		{"kick[gamemode=minigames", "", false, nil, false},
+	}
+
+	for _, test := range tests {
+		entry, err := ParseEntry(test.entry)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseEntry(%q) returned error %v, expected valid to be %v", test.entry, err, test.valid)
+			continue
+		}
+
+		if !test.valid {
+			continue
+		}
+
+		if entry.Node != test.node || entry.Negated != test.neg || len(entry.Context) != len(test.ctx) {
+			t.Errorf("ParseEntry(%q) = %+v, expected node %q, negated %v and context %v", test.entry, entry, test.node, test.neg, test.ctx)
+			continue
+		}
+
+		for key, value := range test.ctx {
+			if entry.Context[key] != value {
+				t.Errorf("ParseEntry(%q) has context %v, expected %v", test.entry, entry.Context, test.ctx)
+			}
+		}
+	}
+}
+
+func TestCheckContext(t *testing.T) {
+	minigames := Context{ContextServer: "minigames-0", ContextGamemode: "minigames", ContextProxy: "proxy-0"}
+	lobby := Context{ContextServer: "lobby-0", ContextGamemode: "lobby", ContextProxy: "proxy-0"}
+
+	tests := []struct {
+		name    string
+		granted []string
+		ctx     Context
+		result  Result
+	}{
+		{"scoped grant in its context", []string{"kick[gamemode=minigames]"}, minigames, Allow},
+		{"scoped grant in another context", []string{"kick[gamemode=minigames]"}, lobby, Undefined},
+		{"scoped grant without context", []string{"kick[gamemode=minigames]"}, nil, Undefined},
+		{"gamemode is case insensitive", []string{"kick[gamemode=MiniGames]"}, minigames, Allow},
+		{"every key must match", []string{"kick[gamemode=minigames,proxy=proxy-1]"}, minigames, Undefined},
+		{"scoped grant overrides global negation", []string{"-kick", "kick[gamemode=minigames]"}, minigames, Allow},
+		{"global negation applies elsewhere", []string{"-kick", "kick[gamemode=minigames]"}, lobby, Deny},
+		{"scoped negation overrides global grant", []string{"kick", "-kick[server=lobby-0]"}, lobby, Deny},
+		{"more specific node overrides scoped wildcard", []string{"-*[gamemode=lobby]", "kick"}, lobby, Allow},
+		{"invalid entries are ignored", []string{"kick[gamemode", "-kick"}, lobby, Deny},
+	}
+
+	for _, test := range tests {
+		if got := Check(test.granted, "kick", test.ctx); got != test.result {
+			t.Errorf("%s: Check(%v, %q, %v) = %v, expected %v", test.name, test.granted, "kick", test.ctx, got, test.result)
+		}
+	}
+}
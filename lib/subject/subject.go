@@ -0,0 +1,29 @@
+// Package subject matches NATS-style subjects, which are split into tokens at dots, like
+// "csmc.default.network". In a pattern, "*" matches a single token and ">" as the last token matches one or
+// more tokens. Both KV keys and messaging topics are matched this way, the same as NATS does.
+package subject
+
+import "strings"
+
+// Match reports whether subject matches pattern. A ">" that is not the last token of the pattern matches
+// nothing, because NATS rejects such patterns.
+func Match(pattern, subject string) bool {
+	p := strings.Split(pattern, ".")
+	s := strings.Split(subject, ".")
+
+	for i, token := range p {
+		if token == ">" {
+			return i == len(p)-1 && len(s) > i
+		}
+
+		if i >= len(s) {
+			return false
+		}
+
+		if token != "*" && token != s[i] {
+			return false
+		}
+	}
+
+	return len(p) == len(s)
+}
@@ -0,0 +1,33 @@
+package subject
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		match   bool
+	}{
+		{"csmc.default.network", "csmc.default.network", true},
+		{"csmc.default.network", "csmc.default.other", false},
+		{"csmc.default", "csmc.default.network", false},
+		{"csmc.default.network.proxy", "csmc.default.network", false},
+		{"csmc.*.network", "csmc.default.network", true},
+		{"csmc.*", "csmc.default.network", false},
+		{"csmc.*", "csmc", false},
+		{"*.network", "default.network", true},
+		{"*.network", "default.other", false},
+		{"csmc.>", "csmc.default.network", true},
+		{"csmc.>", "csmc", false},
+		{">", "csmc", true},
+		{">", "csmc.default", true},
+		{"csmc.>.network", "csmc.default.network", false},
+		{"csmc.>.network", "csmc.>.network", false},
+	}
+
+	for _, test := range tests {
+		if got := Match(test.pattern, test.subject); got != test.match {
+			t.Errorf("Match(%q, %q) = %v, expected %v", test.pattern, test.subject, got, test.match)
+		}
+	}
+}
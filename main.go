@@ -8,6 +8,7 @@ import (
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/bossbar"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/core"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/fallback"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/maintenance"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/motd"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/permissions"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/resourcepack"
@@ -51,9 +52,8 @@ func main() {
 		func(_ *hosting.Hosting) (proxy.Plugin, error) {
 			return permissions.New(perms)
 		},
-		func(h *hosting.Hosting) (proxy.Plugin, error) {
-			return whitelist.New(h, perms)
-		},
+		whitelist.New,
+		maintenance.New,
 		motd.New,
 		tab.New,
 		bossbar.New,
@@ -6,10 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
-	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/messaging"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/rpc"
 	"github.com/robinbraemer/event"
 	"github.com/rs/zerolog"
@@ -25,10 +25,18 @@ type CorePlugin struct {
 	prx         *proxy.Proxy
 	h           *hosting.Hosting
 	mgr         *hosting.InstanceManager
+	gamemodes   *hosting.GamemodeCatalog
 	instancesKV kv.Bucket
+	players     *hosting.PlayerRegistry
+	rpc         *rpc.Server
+	rpcClient   *rpc.Client
 	l           zerolog.Logger
 }
 
+// rpcQueueGroup is the queue group of all proxies on the network subject, so that every request sent to
+// the network is handled by a single proxy, which routes it to the proxy holding the player.
+const rpcQueueGroup = "proxies"
+
 func New(h *hosting.Hosting) (proxy.Plugin, error) {
 	return proxy.Plugin{
 		Name: "Core",
@@ -45,7 +53,26 @@ func New(h *hosting.Hosting) (proxy.Plugin, error) {
 				return err
 			}
 
-			p := &CorePlugin{prx: prx, h: h, instancesKV: instancesKV, mgr: mgr, l: l}
+			gamemodes, err := h.Gamemodes(ctx)
+			if err != nil {
+				return err
+			}
+
+			players, err := h.Players(ctx)
+			if err != nil {
+				return err
+			}
+
+			p := &CorePlugin{
+				prx:         prx,
+				h:           h,
+				instancesKV: instancesKV,
+				mgr:         mgr,
+				gamemodes:   gamemodes,
+				players:     players,
+				rpcClient:   rpc.NewClient(h.Messaging()),
+				l:           l,
+			}
 
 			return p.Init(ctx)
 		},
@@ -54,7 +81,7 @@ func New(h *hosting.Hosting) (proxy.Plugin, error) {
 
 func (p *CorePlugin) Init(ctx context.Context) error {
 	go func() {
-		watcher, err := p.instancesKV.WatchAll(ctx)
+		watcher, err := kv.WatchResuming(ctx, p.instancesKV, ">")
 		if err != nil {
 			p.l.Fatal().Err(err).Msg("Failed to watch all instances")
 		}
@@ -74,7 +101,7 @@ func (p *CorePlugin) Init(ctx context.Context) error {
 					continue
 				}
 
-				p.l.Info().Msgf("Parsed pod info for %s: %+v", podName, info)
+				p.l.Debug().Msgf("Parsed pod info for %s: %+v", podName, info)
 
 				if err := p.mgr.Register(ctx, podName, info); err != nil {
 					p.l.Error().Err(err).Msgf("Failed to register server %s", podName)
@@ -88,191 +115,177 @@ func (p *CorePlugin) Init(ctx context.Context) error {
 		}
 	}()
 
-	{
-		errorReqRes, err := json.Marshal(&rpc.TransferPlayerResponse{Status: rpc.StatusError})
-		if err != nil {
-			p.l.Error().Err(err).Msg("Failed to marshal error transfer player response")
-			return err
-		}
+	go p.mgr.RunExpiry(ctx)
+	go p.mgr.RunHealthChecks(ctx)
+	go p.players.Run(ctx)
 
-		errorRes, err := json.Marshal(&rpc.Response{Type: rpc.TypeTransferPlayer, Data: string(errorReqRes)})
-		if err != nil {
-			p.l.Error().Err(err).Msg("Failed to marshal error response")
-			return err
-		}
+	p.rpc = rpc.NewServer(p.h.Messaging(), 10*time.Second)
+	rpc.Handle(p.rpc, rpc.TypeTransferPlayer, p.handleTransferPlayer)
 
-		err = p.h.Messaging().Subscribe(p.h.Info.RPCNetworkSubject(), func(msg messaging.Message) {
-			l := p.l.With().Bytes("data", msg.Data()).Logger()
-			l.Trace().Msgf("Received raw request")
+	if err := p.rpc.ListenQueue(p.h.Info.RPCNetworkSubject(), rpcQueueGroup); err != nil {
+		p.l.Error().Err(err).Msg("Failed to subscribe to RPC network")
 
-			payload := &rpc.Request{}
-			if err := json.Unmarshal(msg.Data(), payload); err != nil {
-				l.Error().Err(err).Msg("Failed to unmarshal request")
-				return
-			}
-
-			if payload.Type != rpc.TypeTransferPlayer {
-				l.Trace().Msgf("Ignoring request of type %s", payload.Type)
-
-				if err := msg.Nak(); err != nil {
-					l.Error().Err(err).Msg("Failed to nack request")
-				}
-
-				return
-			}
-
-			req := &rpc.TransferPlayerRequest{}
-			if err := json.Unmarshal([]byte(payload.Data), req); err != nil {
-				l.Error().Err(err).Msg("Failed to unmarshal transfer player request")
-
-				if err := msg.Nak(); err != nil {
-					l.Error().Err(err).Msg("Failed to nack transfer player request")
-				}
-
-				return
-			}
-
-			l = p.l.With().Str("player", req.UUID.String()).Str("destination", req.Destination).Logger()
-			l.Trace().Msgf("Transfer player request")
+		return err
+	}
 
-			player := p.prx.Player(req.UUID)
-			if player == nil {
-				l.Trace().Msg("Player not found on this proxy")
+	if err := p.rpc.Listen(p.h.Info.RPCProxySubject()); err != nil {
+		p.l.Error().Err(err).Msg("Failed to subscribe to RPC proxy subject")
 
-				if err := msg.Nak(); err != nil {
-					l.Error().Err(err).Msg("Failed to nack transfer player request")
-				}
+		return err
+	}
 
-				return
+	p.prx.Command().Register(brigodier.Literal("ping").
+		Executes(command.Command(func(c *command.Context) error {
+			player, ok := c.Source.(proxy.Player)
+			if !ok {
+				return c.Source.SendMessage(&Text{Content: "Pong!"})
 			}
 
-			var newServer proxy.RegisteredServer
-			for _, s := range p.prx.Servers() {
-				sName := s.ServerInfo().Name()
-
-				if sName == req.Destination {
-					newServer = s
-					break
-				}
-
-				if strings.HasPrefix(sName, req.Destination+"-") {
-					newServer = s
-					break
-				}
-			}
-			if newServer == nil {
-				p.l.Err(err).Msgf("Server %s not found", req.Destination)
-				msg.Nak()
-				return
-			}
+			return player.SendMessage(&Text{
+				Content: fmt.Sprintf("Pong! Your ping is %s", player.Ping()),
+				S:       Style{Color: color.Green},
+			})
+		})),
+	)
 
-			c, err := player.CreateConnectionRequest(newServer).Connect(msg.Context())
-			if err != nil {
-				p.l.Error().Err(err).Msgf("Failed to connect player %s to server %s", req.UUID, req.Destination)
+	event.Subscribe(p.prx.Event(), 0, p.onServerSwitch)
+	event.Subscribe(p.prx.Event(), 0, p.onChooseServer)
+	event.Subscribe(p.prx.Event(), 0, p.onPostLogin)
+	event.Subscribe(p.prx.Event(), 0, p.onDisconnect)
+	// Run after all other shutdown handlers, as they may still need messaging and KV
+	event.Subscribe(p.prx.Event(), -100, p.onShutdown)
 
-				if err := msg.Respond(errorRes); err != nil {
-					p.l.Error().Err(err).Msg("Failed to respond to transfer player request: %v")
-				}
+	return nil
+}
 
-				return
-			}
+func (p *CorePlugin) handleTransferPlayer(ctx context.Context, req *rpc.TransferPlayerRequest) (*rpc.TransferPlayerResponse, error) {
+	l := p.l.With().Str("player", req.UUID.String()).Str("destination", req.Destination).Logger()
+	l.Trace().Msgf("Transfer player request")
 
-			if c.Status() == proxy.AlreadyConnectedConnectionStatus {
-				p.l.Info().Msgf("Player %s already connected to server %s", req.UUID, req.Destination)
+	player := p.prx.Player(req.UUID)
+	if player == nil {
+		return p.forwardTransferPlayer(ctx, req)
+	}
 
-				if err := msg.Ack(); err != nil {
-					p.l.Error().Err(err).Msg("Failed to ack transfer player request")
-				}
+	var newServer proxy.RegisteredServer
+	for _, s := range p.prx.Servers() {
+		sName := s.ServerInfo().Name()
 
-				return
-			} else if c.Status() != proxy.SuccessConnectionStatus {
-				p.l.Printf("Failed to connect player %s to server %s: %v: %v", req.UUID, req.Destination, c.Status(), c.Reason())
+		if sName == req.Destination {
+			newServer = s
+			break
+		}
 
-				if err := msg.Respond(errorRes); err != nil {
-					p.l.Error().Err(err).Msg("Failed to respond to transfer player request: %v")
-				}
+		if strings.HasPrefix(sName, req.Destination+"-") {
+			newServer = s
+			break
+		}
+	}
+	if newServer == nil {
+		return nil, rpc.Errorf(rpc.StatusNotFound, "server %s not found", req.Destination)
+	}
 
-				return
-			}
+	c, err := player.CreateConnectionRequest(newServer).Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-			reqRes, err := json.Marshal(&rpc.TransferPlayerResponse{Status: rpc.StatusOk})
-			if err != nil {
-				p.l.Error().Err(err).Msg("Failed to marshal transfer player response")
+	if c.Status() == proxy.AlreadyConnectedConnectionStatus {
+		l.Info().Msgf("Player %s already connected to server %s", req.UUID, req.Destination)
+	} else if c.Status() != proxy.SuccessConnectionStatus {
+		return nil, rpc.Errorf(rpc.StatusError, "failed to connect player %s to server %s: %v", req.UUID, req.Destination, c.Status())
+	} else {
+		l.Info().Msgf("Player %s transferred to server %s", req.UUID, req.Destination)
+	}
 
-				if err := msg.Respond(errorRes); err != nil {
-					p.l.Error().Err(err).Msg("Failed to respond to transfer player request: %v")
-				}
+	return &rpc.TransferPlayerResponse{Status: rpc.StatusOk}, nil
+}
 
-				return
-			}
+// forwardTransferPlayer sends the request to the proxy the player is connected to.
+func (p *CorePlugin) forwardTransferPlayer(ctx context.Context, req *rpc.TransferPlayerRequest) (*rpc.TransferPlayerResponse, error) {
+	owner, err := p.players.Proxy(ctx, req.UUID.String())
+	if errors.Is(err, hosting.ErrPlayerNotOnline) {
+		return nil, rpc.Errorf(rpc.StatusNotFound, "player %s is not online", req.UUID)
+	} else if err != nil {
+		return nil, err
+	}
 
-			res, err := json.Marshal(&rpc.Response{Type: payload.Type, Data: string(reqRes)})
-			if err != nil {
-				p.l.Error().Err(err).Msg("Failed to marshal response")
+	// The registry may still point to this proxy right after the player disconnected
+	if owner == p.h.Info.PodName {
+		return nil, rpc.Errorf(rpc.StatusNotFound, "player %s is not online", req.UUID)
+	}
 
-				if err := msg.Respond(errorRes); err != nil {
-					p.l.Error().Err(err).Msg("Failed to respond to transfer player request: %v")
-				}
+	p.l.Trace().Str("player", req.UUID.String()).Msgf("Forwarding transfer player request to %s", owner)
 
-				return
-			}
+	return rpc.Call[rpc.TransferPlayerRequest, rpc.TransferPlayerResponse](ctx, p.rpcClient, p.h.Info.RPCProxySubjectOf(owner), rpc.TypeTransferPlayer, req)
+}
 
-			if err := msg.Respond(res); err != nil {
-				l.Error().Err(err).Msg("Failed to respond to transfer player request: %v")
-			}
+func (p *CorePlugin) onPostLogin(e *proxy.PostLoginEvent) {
+	if err := p.players.Connected(e.Player().Context(), e.Player().ID().String(), e.Player().Username()); err != nil {
+		p.l.Error().Err(err).Msgf("Failed to register player %s", e.Player().ID())
+	}
+}
 
-			l.Info().Msgf("Player %s transferred to server %s", req.UUID, req.Destination)
-		})
-		if err != nil {
-			p.l.Error().Err(err).Msg("Failed to subscribe to RPC network")
+func (p *CorePlugin) onDisconnect(e *proxy.DisconnectEvent) {
+	// The player's context is already cancelled at this point
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-			return err
-		}
+	if err := p.players.Disconnected(ctx, e.Player().ID().String()); err != nil {
+		p.l.Error().Err(err).Msgf("Failed to unregister player %s", e.Player().ID())
 	}
+}
 
-	p.prx.Command().Register(brigodier.Literal("ping").
-		Executes(command.Command(func(c *command.Context) error {
-			player, ok := c.Source.(proxy.Player)
-			if !ok {
-				return c.Source.SendMessage(&Text{Content: "Pong!"})
-			}
-
-			return player.SendMessage(&Text{
-				Content: fmt.Sprintf("Pong! Your ping is %s", player.Ping()),
-				S:       Style{Color: color.Green},
-			})
-		})),
-	)
+// fallbackGamemode is joined by players if no gamemode of the catalog is marked as default.
+const fallbackGamemode = "lobby"
 
-	event.Subscribe(p.prx.Event(), 0, p.onServerSwitch)
-	event.Subscribe(p.prx.Event(), 0, p.onChooseServer)
+func (p *CorePlugin) onChooseServer(e *proxy.PlayerChooseInitialServerEvent) {
+	name := fallbackGamemode
+	if def, ok := p.gamemodes.Default(); ok {
+		name = def.Name
+	} else {
+		p.l.Debug().Msgf("No default gamemode configured, falling back to %s", fallbackGamemode)
+	}
 
-	return nil
-}
+	gamemodes := append([]string{name}, p.gamemodes.FallbackChain(name)...)
 
-func (p *CorePlugin) onChooseServer(e *proxy.PlayerChooseInitialServerEvent) {
-	server, err := p.mgr.GetRandomServerOfGamemode(e.Player().Context(), "lobby")
+	server, gm, err := p.mgr.ChooseServerForPlayer(e.Player().Context(), e.Player(), gamemodes)
 	if errors.Is(err, hosting.ErrNoServersAvailable) {
 		p.l.Warn().Msgf("No servers available for player %s", e.Player().ID())
 		return
 	} else if err != nil {
-		p.l.Error().Err(err).Msg("Failed to get servers of gamemode lobby")
-		// Fallback to default
-		e.SetInitialServer(p.prx.Server("lobby-0"))
+		p.l.Error().Err(err).Msgf("Failed to choose server of gamemode %s", name)
 		return
 	}
 
-	p.l.Trace().Msgf("Chose server %s for player %s", server.ServerInfo().Name(), e.Player().ID())
+	p.l.Trace().Msgf("Chose server %s of gamemode %s for player %s", server.ServerInfo().Name(), gm.Name, e.Player().ID())
 
 	e.SetInitialServer(server)
 }
 
+func (p *CorePlugin) onShutdown(e *proxy.ShutdownEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := p.rpc.Close(); err != nil {
+		p.l.Error().Err(err).Msg("Failed to close RPC server")
+	}
+
+	if err := p.h.Close(ctx); err != nil {
+		p.l.Error().Err(err).Msg("Failed to close hosting")
+	}
+}
+
 func (p *CorePlugin) onServerSwitch(e *proxy.ServerPostConnectEvent) {
 	s := e.Player().CurrentServer()
 	if s == nil {
 		return
 	}
 
+	if err := p.players.SwitchedServer(e.Player().Context(), e.Player().ID().String(), s.Server().ServerInfo().Name()); err != nil {
+		p.l.Error().Err(err).Msgf("Failed to update server of player %s", e.Player().ID())
+	}
+
 	_ = e.Player().SendMessage(&Text{
 		S: Style{Color: color.Aqua},
 		Extra: []Component{
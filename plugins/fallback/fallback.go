@@ -14,10 +14,11 @@ import (
 )
 
 type FallbackPlugin struct {
-	prx *proxy.Proxy
-	h   *hosting.Hosting
-	mgr *hosting.InstanceManager
-	l   zerolog.Logger
+	prx       *proxy.Proxy
+	h         *hosting.Hosting
+	mgr       *hosting.InstanceManager
+	gamemodes *hosting.GamemodeCatalog
+	l         zerolog.Logger
 }
 
 func New(h *hosting.Hosting) (proxy.Plugin, error) {
@@ -29,7 +30,12 @@ func New(h *hosting.Hosting) (proxy.Plugin, error) {
 				return err
 			}
 
-			p := &FallbackPlugin{prx: prx, h: h, mgr: mgr, l: log.With().Str("plugin", "fallback").Logger()}
+			gamemodes, err := h.Gamemodes(ctx)
+			if err != nil {
+				return err
+			}
+
+			p := &FallbackPlugin{prx: prx, h: h, mgr: mgr, gamemodes: gamemodes, l: log.With().Str("plugin", "fallback").Logger()}
 
 			return p.Init(ctx)
 		},
@@ -49,18 +55,25 @@ func (p *FallbackPlugin) onServerDisconnect(e *proxy.KickedFromServerEvent) {
 
 	p.l.Println("Got kicked from server")
 
-	server, err := p.mgr.GetRandomServerOfGamemode(e.Player().Context(), "lobby")
+	kickedFrom := e.Server().ServerInfo().Name()
+
+	var chain []string
+	if info, ok := p.mgr.Instance(kickedFrom); ok {
+		chain = p.gamemodes.FallbackChain(info.Gamemode)
+	} else if def, ok := p.gamemodes.Default(); ok {
+		chain = []string{def.Name}
+	}
+
+	server, gm, err := p.mgr.ChooseServerForPlayer(e.Player().Context(), e.Player(), chain, kickedFrom)
 	if errors.Is(err, hosting.ErrNoServersAvailable) {
-		l.Warn().Msg("No servers available")
+		l.Warn().Msgf("No servers available in fallback chain %v", chain)
 		return
 	} else if err != nil {
-		l.Error().Err(err).Msgf("Failed to get random server of gamemode lobby")
-		// Fallback to default
-		e.Player().CreateConnectionRequest(p.prx.Server("lobby-0"))
+		l.Error().Err(err).Msgf("Failed to choose server of fallback chain %v", chain)
 		return
 	}
 
-	l.Info().Msgf("Chose server %s", server.ServerInfo().Name())
+	l.Info().Msgf("Chose server %s of gamemode %s", server.ServerInfo().Name(), gm.Name)
 
 	e.SetResult(&proxy.RedirectPlayerKickResult{
 		Server: server,
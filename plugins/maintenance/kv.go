@@ -0,0 +1,216 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/mini"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultKickMessage = "<color:red>The network is under maintenance.\n<color:gray>Please try again later."
+)
+
+// Schedule is a planned maintenance window. A zero Start means maintenance only starts when it is enabled,
+// and a zero End means it lasts until it is disabled.
+type Schedule struct {
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+}
+
+// Maintenance is the maintenance state of the network, shared by all proxies through the maintenance
+// bucket. Messages are written in lib/mini markup.
+type Maintenance struct {
+	Enabled     bool     `json:"enabled"`
+	KickMessage string   `json:"kickMessage"`
+	MOTD        []string `json:"motd"`
+	Schedule    Schedule `json:"schedule"`
+	m           sync.RWMutex
+	kv          kv.Bucket
+	changed     chan struct{}
+	l           zerolog.Logger
+}
+
+func NewKVMaintenance(ctx context.Context, h *hosting.Hosting) (*Maintenance, error) {
+	bucket, err := h.KV().Bucket(ctx, h.Info.KVNetworkKey()+"_maintenance")
+	if err != nil {
+		return nil, err
+	}
+
+	l := log.With().Str("bucket", bucket.Name()).Logger()
+
+	m := &Maintenance{
+		KickMessage: DefaultKickMessage,
+		kv:          bucket,
+		changed:     make(chan struct{}, 1),
+		l:           l,
+	}
+
+	watcher, err := kv.WatchResuming(ctx, bucket, ">")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for key := range watcher.Changes() {
+			if key == nil {
+				continue
+			}
+
+			l.Trace().Msgf("Key %s changed: %s", key.Key, key.Value)
+
+			m.m.Lock()
+			if err := m.apply(key); err != nil {
+				l.Error().Err(err).Msgf("Failed to apply %s key", key.Key)
+			}
+			m.m.Unlock()
+
+			m.notify()
+		}
+	}()
+
+	return m, nil
+}
+
+// apply updates the field stored under the changed key, resetting it to its default if it was deleted or
+// is invalid.
+func (m *Maintenance) apply(key *kv.Value) error {
+	var field any
+
+	switch key.Key {
+	case "enabled":
+		m.Enabled = false
+		field = &m.Enabled
+	case "kickMessage":
+		m.KickMessage = DefaultKickMessage
+		field = &m.KickMessage
+	case "motd":
+		m.MOTD = nil
+		field = &m.MOTD
+	case "schedule":
+		m.Schedule = Schedule{}
+		field = &m.Schedule
+	default:
+		return nil
+	}
+
+	if key.Operation == kv.Delete {
+		return nil
+	}
+
+	if err := json.Unmarshal(key.Value, field); err != nil {
+		return err
+	}
+
+	// Messages with markup that can't be rendered are rejected, so the defaults are shown instead
+	switch key.Key {
+	case "kickMessage":
+		if err := mini.Validate(m.KickMessage); err != nil {
+			m.KickMessage = DefaultKickMessage
+			return err
+		}
+	case "motd":
+		if err := mini.Validate(strings.Join(m.MOTD, "\n")); err != nil {
+			m.MOTD = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Maintenance) notify() {
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Changed receives a value whenever the maintenance state changed on any proxy.
+func (m *Maintenance) Changed() <-chan struct{} {
+	return m.changed
+}
+
+// IsActive reports whether the network is under maintenance at the given time, either because it was
+// enabled or because the scheduled window started. A scheduled end stops both.
+func (m *Maintenance) IsActive(now time.Time) bool {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	if !m.Schedule.End.IsZero() && !now.Before(m.Schedule.End) {
+		return false
+	}
+
+	return m.Enabled || (!m.Schedule.Start.IsZero() && !now.Before(m.Schedule.Start))
+}
+
+// Upcoming returns the scheduled start if maintenance is not active yet.
+func (m *Maintenance) Upcoming(now time.Time) (time.Time, bool) {
+	if m.IsActive(now) {
+		return time.Time{}, false
+	}
+
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	if m.Schedule.Start.IsZero() || !now.Before(m.Schedule.Start) {
+		return time.Time{}, false
+	}
+
+	return m.Schedule.Start, true
+}
+
+func (m *Maintenance) Messages() (kickMessage string, motd []string) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	return m.KickMessage, m.MOTD
+}
+
+func (m *Maintenance) CurrentSchedule() Schedule {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	return m.Schedule
+}
+
+// Enable starts maintenance immediately and drops any schedule.
+func (m *Maintenance) Enable(ctx context.Context) error {
+	return m.save(ctx, true, Schedule{})
+}
+
+// Disable stops maintenance immediately and drops any schedule.
+func (m *Maintenance) Disable(ctx context.Context) error {
+	return m.save(ctx, false, Schedule{})
+}
+
+// ScheduleWindow plans maintenance from start to end, which may be zero to last until it is disabled.
+func (m *Maintenance) ScheduleWindow(ctx context.Context, start, end time.Time) error {
+	return m.save(ctx, false, Schedule{Start: start, End: end})
+}
+
+func (m *Maintenance) save(ctx context.Context, enabled bool, schedule Schedule) error {
+	m.m.Lock()
+	m.Enabled = enabled
+	m.Schedule = schedule
+	m.m.Unlock()
+
+	if err := hosting.SetKeyToKV(ctx, m.kv, "schedule", schedule); err != nil {
+		return err
+	}
+
+	if err := hosting.SetKeyToKV(ctx, m.kv, "enabled", enabled); err != nil {
+		return err
+	}
+
+	m.notify()
+
+	return nil
+}
@@ -0,0 +1,323 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/mini"
+	"github.com/robinbraemer/event"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.minekube.com/brigodier"
+	"go.minekube.com/common/minecraft/color"
+	"go.minekube.com/common/minecraft/component"
+	"go.minekube.com/gate/pkg/command"
+	"go.minekube.com/gate/pkg/edition/java/proxy"
+)
+
+const (
+	PermissionBypass = "maintenance.bypass"
+	PermissionManage = "maintenance.manage"
+)
+
+// countdown are the times before a scheduled start at which players get warned.
+var countdown = []time.Duration{
+	30 * time.Minute, 15 * time.Minute, 10 * time.Minute, 5 * time.Minute, time.Minute,
+	30 * time.Second, 10 * time.Second, 5 * time.Second, 4 * time.Second, 3 * time.Second, 2 * time.Second, time.Second,
+}
+
+type MaintenancePlugin struct {
+	prx         *proxy.Proxy
+	maintenance *Maintenance
+	l           zerolog.Logger
+}
+
+func New(h *hosting.Hosting) (proxy.Plugin, error) {
+	return proxy.Plugin{
+		Name: "Maintenance",
+		Init: func(ctx context.Context, prx *proxy.Proxy) error {
+			maintenance, err := NewKVMaintenance(ctx, h)
+			if err != nil {
+				return err
+			}
+
+			p := &MaintenancePlugin{
+				prx:         prx,
+				maintenance: maintenance,
+				l:           log.With().Str("plugin", "maintenance").Logger(),
+			}
+
+			return p.Init(ctx)
+		},
+	}, nil
+}
+
+func (p *MaintenancePlugin) Init(ctx context.Context) error {
+	event.Subscribe(p.prx.Event(), 0, p.onLogin)
+	// Run after the MOTD plugin, so the maintenance MOTD replaces the regular one
+	event.Subscribe(p.prx.Event(), -10, p.onPing)
+	p.prx.Command().Register(p.command())
+
+	go p.run(ctx)
+
+	return nil
+}
+
+func (p *MaintenancePlugin) canBypass(player proxy.Player) bool {
+	return player.HasPermission(PermissionBypass)
+}
+
+func (p *MaintenancePlugin) kickMessage() component.Component {
+	kickMessage, _ := p.maintenance.Messages()
+
+	return mini.Parse(kickMessage)
+}
+
+func (p *MaintenancePlugin) onLogin(e *proxy.LoginEvent) {
+	if !p.maintenance.IsActive(time.Now()) || p.canBypass(e.Player()) {
+		return
+	}
+
+	e.Deny(p.kickMessage())
+}
+
+func (p *MaintenancePlugin) onPing(e *proxy.PingEvent) {
+	if !p.maintenance.IsActive(time.Now()) {
+		return
+	}
+
+	ping := e.Ping()
+
+	_, motd := p.maintenance.Messages()
+	if len(motd) > 0 {
+		ping.Description = mini.Parse(strings.Join(motd, "\n"))
+	}
+
+	// An unknown protocol makes clients show the version name in red instead of the player count
+	ping.Version.Name = "Maintenance"
+	ping.Version.Protocol = -1
+}
+
+// run kicks players when maintenance starts and counts down to scheduled starts until ctx is cancelled.
+func (p *MaintenancePlugin) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	active := p.maintenance.IsActive(time.Now())
+	if active {
+		p.kickAll()
+	}
+
+	var announcedStart time.Time
+	announced := make(map[time.Duration]bool)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.maintenance.Changed():
+		}
+
+		now := time.Now()
+
+		if isActive := p.maintenance.IsActive(now); isActive != active {
+			active = isActive
+
+			if active {
+				p.l.Info().Msg("Maintenance started")
+				p.kickAll()
+			} else {
+				p.l.Info().Msg("Maintenance ended")
+			}
+		}
+
+		start, ok := p.maintenance.Upcoming(now)
+		if !ok {
+			continue
+		}
+
+		if !start.Equal(announcedStart) {
+			announcedStart = start
+			clear(announced)
+		}
+
+		remaining := start.Sub(now)
+		for _, mark := range countdown {
+			if remaining > mark || remaining <= mark-time.Second || announced[mark] {
+				continue
+			}
+
+			announced[mark] = true
+			p.broadcast(&component.Text{
+				Content: fmt.Sprintf("The network goes into maintenance in %s!", mark),
+				S:       component.Style{Color: color.Gold},
+			})
+		}
+	}
+}
+
+func (p *MaintenancePlugin) kickAll() {
+	reason := p.kickMessage()
+
+	for _, player := range p.prx.Players() {
+		if p.canBypass(player) {
+			continue
+		}
+
+		player.Disconnect(reason)
+	}
+}
+
+func (p *MaintenancePlugin) broadcast(msg component.Component) {
+	for _, player := range p.prx.Players() {
+		if err := player.SendMessage(msg); err != nil {
+			p.l.Error().Err(err).Msgf("Failed to send message to %s", player.Username())
+		}
+	}
+}
+
+func (p *MaintenancePlugin) command() brigodier.LiteralNodeBuilder {
+	return brigodier.Literal("maintenance").
+		Then(brigodier.
+			Literal("status").
+			Executes(p.statusCommand())).
+		Then(brigodier.
+			Literal("on").
+			Executes(p.enableCommand())).
+		Then(brigodier.
+			Literal("off").
+			Executes(p.disableCommand())).
+		Then(brigodier.
+			Literal("schedule").
+			Executes(p.usage()).
+			Then(brigodier.
+				Argument("in", brigodier.String).
+				Executes(p.scheduleCommand()).
+				Then(brigodier.
+					Argument("duration", brigodier.String).
+					Executes(p.scheduleCommand())))).
+		Executes(p.statusCommand())
+}
+
+// allowed reports whether the source of the command may manage maintenance. The console always may.
+func (p *MaintenancePlugin) allowed(c *command.Context) bool {
+	player, ok := c.Source.(proxy.Player)
+
+	return !ok || player.HasPermission(PermissionManage)
+}
+
+func (p *MaintenancePlugin) permissionMissing(c *command.Context) error {
+	return c.SendMessage(&component.Text{
+		Content: "You don't have the permission to do that!",
+		S:       component.Style{Color: color.Red},
+	})
+}
+
+func (p *MaintenancePlugin) usage() brigodier.Command {
+	usage := component.Text{Content: "Usage: /maintenance <status/on/off/schedule> [in] [duration]", S: component.Style{Color: color.Red}}
+
+	return command.Command(func(c *command.Context) error {
+		if !p.allowed(c) {
+			return p.permissionMissing(c)
+		}
+
+		return c.SendMessage(&usage)
+	})
+}
+
+func (p *MaintenancePlugin) statusCommand() brigodier.Command {
+	return command.Command(func(c *command.Context) error {
+		if !p.allowed(c) {
+			return p.permissionMissing(c)
+		}
+
+		now := time.Now()
+		schedule := p.maintenance.CurrentSchedule()
+
+		var status string
+		if p.maintenance.IsActive(now) {
+			status = "Maintenance is enabled"
+			if !schedule.End.IsZero() {
+				status += fmt.Sprintf(" and ends in %s", schedule.End.Sub(now).Round(time.Second))
+			}
+		} else if start, ok := p.maintenance.Upcoming(now); ok {
+			status = fmt.Sprintf("Maintenance starts in %s", start.Sub(now).Round(time.Second))
+		} else {
+			status = "Maintenance is disabled"
+		}
+
+		return c.SendMessage(&component.Text{Content: status, S: component.Style{Color: color.Yellow}})
+	})
+}
+
+func (p *MaintenancePlugin) enableCommand() brigodier.Command {
+	enabled := component.Text{Content: "Enabled maintenance!", S: component.Style{Color: color.Green}}
+
+	return command.Command(func(c *command.Context) error {
+		if !p.allowed(c) {
+			return p.permissionMissing(c)
+		}
+
+		if err := p.maintenance.Enable(context.Background()); err != nil {
+			return err
+		}
+
+		return c.SendMessage(&enabled)
+	})
+}
+
+func (p *MaintenancePlugin) disableCommand() brigodier.Command {
+	disabled := component.Text{Content: "Disabled maintenance!", S: component.Style{Color: color.Green}}
+
+	return command.Command(func(c *command.Context) error {
+		if !p.allowed(c) {
+			return p.permissionMissing(c)
+		}
+
+		if err := p.maintenance.Disable(context.Background()); err != nil {
+			return err
+		}
+
+		return c.SendMessage(&disabled)
+	})
+}
+
+func (p *MaintenancePlugin) scheduleCommand() brigodier.Command {
+	return command.Command(func(c *command.Context) error {
+		if !p.allowed(c) {
+			return p.permissionMissing(c)
+		}
+
+		in, err := time.ParseDuration(c.Arguments["in"].Result.(string))
+		if err != nil || in < 0 {
+			return p.usage().Run(c.CommandContext)
+		}
+
+		start := time.Now().Add(in)
+
+		var end time.Time
+		if arg, ok := c.Arguments["duration"]; ok {
+			duration, err := time.ParseDuration(arg.Result.(string))
+			if err != nil || duration <= 0 {
+				return p.usage().Run(c.CommandContext)
+			}
+
+			end = start.Add(duration)
+		}
+
+		if err := p.maintenance.ScheduleWindow(context.Background(), start, end); err != nil {
+			return err
+		}
+
+		msg := fmt.Sprintf("Scheduled maintenance in %s", in)
+		if !end.IsZero() {
+			msg += fmt.Sprintf(" for %s", end.Sub(start))
+		}
+
+		return c.SendMessage(&component.Text{Content: msg + "!", S: component.Style{Color: color.Green}})
+	})
+}
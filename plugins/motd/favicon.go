@@ -0,0 +1,119 @@
+package motd
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/storage"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/favicon"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// FaviconRefreshInterval is how often cached favicons are compared to the images in storage.
+const FaviconRefreshInterval = 30 * time.Second
+
+type cachedFavicon struct {
+	hash [sha256.Size]byte
+	// uri is empty if the image does not exist or is invalid, so missing images aren't read on every ping.
+	uri string
+}
+
+// Favicons loads favicons from storage and caches their encoded form. Cached favicons are re-read
+// periodically, so replacing an image in storage updates the favicon.
+type Favicons struct {
+	strg  storage.Storage
+	cache map[string]cachedFavicon
+	m     sync.RWMutex
+	l     zerolog.Logger
+}
+
+func NewFavicons(strg storage.Storage) *Favicons {
+	return &Favicons{
+		strg:  strg,
+		cache: make(map[string]cachedFavicon),
+		l:     log.With().Str("component", "favicons").Logger(),
+	}
+}
+
+// Get returns the data URI of the favicon stored under key, or an empty string if there is no valid image.
+func (f *Favicons) Get(ctx context.Context, key string) string {
+	f.m.RLock()
+	cached, exists := f.cache[key]
+	f.m.RUnlock()
+
+	if exists {
+		return cached.uri
+	}
+
+	cached = f.load(ctx, key, cachedFavicon{})
+
+	f.m.Lock()
+	f.cache[key] = cached
+	f.m.Unlock()
+
+	return cached.uri
+}
+
+// load reads the image stored under key and encodes it, unless it did not change since previous was loaded.
+func (f *Favicons) load(ctx context.Context, key string, previous cachedFavicon) cachedFavicon {
+	data, err := f.strg.Read(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		f.l.Warn().Msgf("Favicon %s does not exist", key)
+		return cachedFavicon{}
+	} else if err != nil {
+		f.l.Error().Err(err).Msgf("Failed to read favicon %s", key)
+		return previous
+	}
+
+	hash := sha256.Sum256(data)
+	if hash == previous.hash {
+		return previous
+	}
+
+	uri, err := favicon.Encode(data)
+	if err != nil {
+		f.l.Error().Err(err).Msgf("Failed to encode favicon %s", key)
+		return cachedFavicon{hash: hash}
+	}
+
+	f.l.Debug().Msgf("Loaded favicon %s", key)
+
+	return cachedFavicon{hash: hash, uri: uri}
+}
+
+// Refresh reloads every cached favicon whose image changed in storage.
+func (f *Favicons) Refresh(ctx context.Context) {
+	f.m.RLock()
+	cache := make(map[string]cachedFavicon, len(f.cache))
+	for key, cached := range f.cache {
+		cache[key] = cached
+	}
+	f.m.RUnlock()
+
+	for key, cached := range cache {
+		refreshed := f.load(ctx, key, cached)
+
+		f.m.Lock()
+		f.cache[key] = refreshed
+		f.m.Unlock()
+	}
+}
+
+// Run refreshes the cached favicons until ctx is cancelled.
+func (f *Favicons) Run(ctx context.Context) {
+	ticker := time.NewTicker(FaviconRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.Refresh(ctx)
+		}
+	}
+}
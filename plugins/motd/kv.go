@@ -0,0 +1,228 @@
+package motd
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/mini"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSampleSize is the number of player names shown in the server list tooltip, like vanilla does.
+const DefaultSampleSize = 12
+
+type RotationMode string
+
+const (
+	// RotationRandom shows a random MOTD on every ping.
+	RotationRandom RotationMode = "random"
+	// RotationSchedule shows the MOTDs in order, switching every interval. All proxies derive the current
+	// MOTD from the clock, so they show the same one.
+	RotationSchedule RotationMode = "schedule"
+)
+
+// MOTD is a server list description. Lines are written in lib/mini markup and may contain the {pod},
+// {online} and {max} placeholders.
+type MOTD struct {
+	Lines []string `json:"lines"`
+	// Favicon is the storage key of the favicon shown with this MOTD, for example during an event.
+	Favicon string `json:"favicon,omitempty"`
+}
+
+type Rotation struct {
+	Mode     RotationMode `json:"mode"`
+	Interval Duration     `json:"interval"`
+}
+
+// Duration is a time.Duration stored as a string like "30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+type Config struct {
+	// MaxPlayers is the maximum player count shown in the server list. If not set, the network looks
+	// like it has room for one more player.
+	MaxPlayers int `json:"maxPlayers"`
+	// SampleSize is the number of player names shown in the tooltip. A negative value disables the sample.
+	SampleSize int `json:"sampleSize"`
+	// MOTDs are the descriptions to rotate through. The built-in MOTD is shown if there are none.
+	MOTDs    []MOTD   `json:"motds"`
+	Rotation Rotation `json:"rotation"`
+	// Version replaces the version name shown in the server list if set.
+	Version string `json:"version"`
+	// Favicon is the storage key of the default favicon.
+	Favicon string `json:"favicon"`
+	// Favicons are the storage keys of the favicons shown to players connecting through a virtual host.
+	Favicons map[string]string `json:"favicons"`
+	m        sync.RWMutex
+	kv       kv.Bucket
+	l        zerolog.Logger
+}
+
+func NewKVConfig(ctx context.Context, h *hosting.Hosting) (*Config, error) {
+	bucket, err := h.KV().Bucket(ctx, h.Info.KVNetworkKey()+"_motd")
+	if err != nil {
+		return nil, err
+	}
+
+	l := log.With().Str("bucket", bucket.Name()).Logger()
+
+	c := &Config{
+		SampleSize: DefaultSampleSize,
+		kv:         bucket,
+		l:          l,
+	}
+
+	watcher, err := kv.WatchResuming(ctx, bucket, ">")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for key := range watcher.Changes() {
+			if key == nil {
+				continue
+			}
+
+			l.Trace().Msgf("Key %s changed: %s", key.Key, key.Value)
+
+			c.m.Lock()
+			if err := c.apply(key); err != nil {
+				l.Error().Err(err).Msgf("Failed to apply %s key", key.Key)
+			}
+			c.m.Unlock()
+		}
+	}()
+
+	return c, nil
+}
+
+// apply updates the field stored under the changed key, resetting it to its default if it was deleted or
+// is invalid.
+func (c *Config) apply(key *kv.Value) error {
+	var field any
+
+	switch key.Key {
+	case "maxPlayers":
+		c.MaxPlayers = 0
+		field = &c.MaxPlayers
+	case "sampleSize":
+		c.SampleSize = DefaultSampleSize
+		field = &c.SampleSize
+	case "motds":
+		c.MOTDs = nil
+		field = &c.MOTDs
+	case "rotation":
+		c.Rotation = Rotation{}
+		field = &c.Rotation
+	case "version":
+		c.Version = ""
+		field = &c.Version
+	case "favicon":
+		c.Favicon = ""
+		field = &c.Favicon
+	case "favicons":
+		c.Favicons = nil
+		field = &c.Favicons
+	default:
+		return nil
+	}
+
+	if key.Operation == kv.Delete {
+		return nil
+	}
+
+	if err := json.Unmarshal(key.Value, field); err != nil {
+		return err
+	}
+
+	// MOTDs with markup that can't be rendered are rejected as a whole, so the default is shown instead
+	for _, motd := range c.MOTDs {
+		if err := mini.Validate(strings.Join(motd.Lines, "\n")); err != nil {
+			c.MOTDs = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Players returns the max player count and sample size to report for a network with online players.
+func (c *Config) Players(online int) (maxPlayers int, sampleSize int) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	maxPlayers = c.MaxPlayers
+	if maxPlayers <= 0 {
+		maxPlayers = online + 1
+	}
+
+	return maxPlayers, c.SampleSize
+}
+
+// Current returns the MOTD to show at the given time according to the rotation.
+func (c *Config) Current(now time.Time) (MOTD, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if len(c.MOTDs) == 0 {
+		return MOTD{}, false
+	}
+
+	if c.Rotation.Mode == RotationSchedule && c.Rotation.Interval > 0 {
+		slot := now.UnixNano() / int64(c.Rotation.Interval)
+		return c.MOTDs[slot%int64(len(c.MOTDs))], true
+	}
+
+	return c.MOTDs[rand.Intn(len(c.MOTDs))], true
+}
+
+// VersionName returns the version name override.
+func (c *Config) VersionName() string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	return c.Version
+}
+
+// FaviconKey returns the storage key of the favicon for a ping through host showing motd. Favicons of the
+// virtual host take precedence over the one of the MOTD, which takes precedence over the default.
+func (c *Config) FaviconKey(host string, motd MOTD) string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if key, ok := c.Favicons[strings.ToLower(host)]; ok {
+		return key
+	}
+
+	if motd.Favicon != "" {
+		return motd.Favicon
+	}
+
+	return c.Favicon
+}
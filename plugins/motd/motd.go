@@ -2,24 +2,47 @@ package motd
 
 import (
 	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/mini"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/util"
 	"github.com/robinbraemer/event"
 	"go.minekube.com/common/minecraft/color"
 	. "go.minekube.com/common/minecraft/component"
+	"go.minekube.com/gate/pkg/edition/java/ping"
 	"go.minekube.com/gate/pkg/edition/java/proxy"
+	"go.minekube.com/gate/pkg/util/favicon"
+	"go.minekube.com/gate/pkg/util/uuid"
 )
 
 type Plugin struct {
-	h *hosting.Hosting
+	h        *hosting.Hosting
+	players  *hosting.PlayerRegistry
+	config   *Config
+	favicons *Favicons
 }
 
 func New(h *hosting.Hosting) (proxy.Plugin, error) {
 	return proxy.Plugin{
 		Name: "MOTD",
 		Init: func(ctx context.Context, proxy *proxy.Proxy) error {
-			plugin := &Plugin{h: h}
+			players, err := h.Players(ctx)
+			if err != nil {
+				return err
+			}
+
+			config, err := NewKVConfig(ctx, h)
+			if err != nil {
+				return err
+			}
+
+			plugin := &Plugin{h: h, players: players, config: config, favicons: NewFavicons(h.Storage())}
+			go plugin.favicons.Run(ctx)
 
 			return plugin.Init(proxy)
 		},
@@ -35,15 +58,98 @@ func (p *Plugin) Init(prx *proxy.Proxy) error {
 func (p *Plugin) onPingEvent() func(e *proxy.PingEvent) {
 	return func(e *proxy.PingEvent) {
 		ping := e.Ping()
-		ping.Description = &Text{
-			Extra: []Component{
-				&Text{Content: "  ᴄѕᴍᴄ ", S: Style{Color: color.Green, Bold: True}},
-				&Text{Content: "-", S: Style{Color: color.Gray, Bold: True}},
-				&Text{Content: " " + util.Latinize("open beta") + "\n", S: Style{Color: color.Yellow, Bold: True}},
-				&Text{Content: "  ɪɴᴅᴇᴠ ᴠᴇʀѕɪᴏɴ - ", S: Style{Color: color.LightPurple, Bold: True}},
-				&Text{Content: util.Latinize(p.h.Info.PodName), S: Style{Color: color.LightPurple, Bold: True}},
-			},
+
+		// Every proxy publishes its players to the registry, so it holds the players of the whole network
+		online := p.players.All()
+		maxPlayers, sampleSize := p.config.Players(len(online))
+
+		ping.Players.Online = len(online)
+		ping.Players.Max = maxPlayers
+		ping.Players.Sample = sample(online, sampleSize)
+
+		motd, ok := p.config.Current(time.Now())
+		if ok {
+			ping.Description = p.render(motd, len(online), maxPlayers)
+		} else {
+			ping.Description = p.defaultDescription()
+		}
+
+		if version := p.config.VersionName(); version != "" {
+			ping.Version.Name = version
+		}
+
+		if key := p.config.FaviconKey(virtualHost(e), motd); key != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			if icon := p.favicons.Get(ctx, key); icon != "" {
+				ping.Favicon = favicon.Favicon(icon)
+			}
+		}
+	}
+}
+
+// virtualHost returns the host name the player used to ping the proxy.
+func virtualHost(e *proxy.PingEvent) string {
+	addr := e.Connection().VirtualHost()
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// render parses the lines of motd after replacing the placeholders.
+func (p *Plugin) render(motd MOTD, online, maxPlayers int) *Text {
+	replacer := strings.NewReplacer(
+		"{pod}", util.Latinize(p.h.Info.PodName),
+		"{online}", strconv.Itoa(online),
+		"{max}", strconv.Itoa(maxPlayers),
+	)
+
+	return mini.Parse(replacer.Replace(strings.Join(motd.Lines, "\n")))
+}
+
+func (p *Plugin) defaultDescription() *Text {
+	return &Text{
+		Extra: []Component{
+			&Text{Content: "  ᴄѕᴍᴄ ", S: Style{Color: color.Green, Bold: True}},
+			&Text{Content: "-", S: Style{Color: color.Gray, Bold: True}},
+			&Text{Content: " " + util.Latinize("open beta") + "\n", S: Style{Color: color.Yellow, Bold: True}},
+			&Text{Content: "  ɪɴᴅᴇᴠ ᴠᴇʀѕɪᴏɴ - ", S: Style{Color: color.LightPurple, Bold: True}},
+			&Text{Content: util.Latinize(p.h.Info.PodName), S: Style{Color: color.LightPurple, Bold: True}},
+		},
+	}
+}
+
+// sample picks up to size random players to show in the server list tooltip.
+func sample(players []hosting.PlayerPresence, size int) []ping.SamplePlayer {
+	if size <= 0 || len(players) == 0 {
+		return nil
+	}
+
+	rand.Shuffle(len(players), func(i, j int) {
+		players[i], players[j] = players[j], players[i]
+	})
+
+	samples := make([]ping.SamplePlayer, 0, size)
+	for _, presence := range players {
+		if len(samples) == size {
+			break
 		}
-		ping.Players.Max = ping.Players.Online + 1
+
+		id, err := uuid.Parse(presence.UUID)
+		if err != nil {
+			continue
+		}
+
+		samples = append(samples, ping.SamplePlayer{Name: presence.Username, ID: id})
 	}
+
+	return samples
 }
@@ -2,14 +2,17 @@ package permissions
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/permnode"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/util"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/util/uuid"
 	"github.com/rs/zerolog"
@@ -19,239 +22,554 @@ import (
 type PermissionUser struct {
 	Groups      []string `json:"groups"`
 	Permissions []string `json:"permissions"`
+	// TemporaryGroups maps groups to the time the membership expires at.
+	TemporaryGroups map[string]time.Time `json:"temporaryGroups,omitempty"`
+	// TemporaryPermissions maps permissions to the time they expire at.
+	TemporaryPermissions map[string]time.Time `json:"temporaryPermissions,omitempty"`
+}
+
+// active returns the groups and permissions of the user that did not expire at now, and the time the
+// next of them expires at, which is zero if none will.
+func (u PermissionUser) active(now time.Time) (groups []string, permissions []string, next time.Time) {
+	groups = slices.Clone(u.Groups)
+	permissions = slices.Clone(u.Permissions)
+
+	collect := func(temporary map[string]time.Time, into *[]string) {
+		for name, expiresAt := range temporary {
+			if !now.Before(expiresAt) {
+				continue
+			}
+
+			*into = append(*into, name)
+			if next.IsZero() || expiresAt.Before(next) {
+				next = expiresAt
+			}
+		}
+	}
+
+	collect(u.TemporaryGroups, &groups)
+	collect(u.TemporaryPermissions, &permissions)
+
+	return groups, permissions, next
+}
+
+// hasExpired reports whether any temporary group membership or permission of the user expired at before.
+func (u PermissionUser) hasExpired(before time.Time) bool {
+	for _, temporary := range []map[string]time.Time{u.TemporaryGroups, u.TemporaryPermissions} {
+		for _, expiresAt := range temporary {
+			if !expiresAt.After(before) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Expiry is a temporary group membership or permission of a player that expired.
+type Expiry struct {
+	Player     string
+	Group      string
+	Permission string
+	ExpiresAt  time.Time
+}
+
+type effectivePermissions struct {
+	sources [][]string
+	// validUntil is when the next temporary entry expires, or zero.
+	validUntil time.Time
 }
 
 type PermissionGroup struct {
 	Prefix      string   `json:"prefix"`
 	Weight      uint8    `json:"weight"`
 	Permissions []string `json:"permissions"`
+	// Parents are the groups this group inherits permissions from.
+	Parents []string `json:"parents,omitempty"`
 }
 
+// DefaultGroup is the group every player is a member of, unless another one is set in the defaultGroup key.
+const DefaultGroup = "default"
+
+var (
+	ErrGroupCycle = errors.New("group inheritance contains a cycle")
+)
+
 type Permissions struct {
-	Users  map[string]PermissionUser
-	Groups map[string]PermissionGroup
-	m      sync.RWMutex
-	h      *hosting.Hosting
-	kv     kv.Bucket
-	l      zerolog.Logger
+	Users        map[string]PermissionUser
+	Groups       map[string]PermissionGroup
+	DefaultGroup string
+	// revisions are the revisions of the cached users and groups by key, so writes don't replace newer
+	// changes the watcher applied meanwhile.
+	revisions map[string]uint64
+	m         sync.RWMutex
+	// effective caches the permission lists of every user in order of precedence.
+	effective map[string]effectivePermissions
+	// generation is increased by every invalidation, so permissions computed from older data are not cached.
+	generation uint64
+	cacheM     sync.Mutex
+	h          *hosting.Hosting
+	kv         kv.Bucket
+	l          zerolog.Logger
 }
 
 func NewKVPermissions(ctx context.Context, h *hosting.Hosting) (*Permissions, error) {
-	kv, err := h.KV().Bucket(ctx, h.Info.KVNetworkKey()+"_permissions")
+	bucket, err := h.KV().Bucket(ctx, h.Info.KVNetworkKey()+"_permissions")
 	if err != nil {
 		return nil, err
 	}
 
-	l := log.With().Str("bucket", kv.Name()).Logger()
+	l := log.With().Str("bucket", bucket.Name()).Logger()
 
 	w := &Permissions{
-		Users:  make(map[string]PermissionUser),
-		Groups: make(map[string]PermissionGroup),
-		h:      h,
-		kv:     kv,
-		l:      l,
+		Users:        make(map[string]PermissionUser),
+		Groups:       make(map[string]PermissionGroup),
+		DefaultGroup: DefaultGroup,
+		revisions:    make(map[string]uint64),
+		effective:    make(map[string]effectivePermissions),
+		h:            h,
+		kv:           bucket,
+		l:            l,
 	}
 
-	watcher, err := kv.WatchAll(context.Background())
-	if err != nil {
+	// Split the legacy keys before watching, so their entries are picked up by the initial replay
+	if err := w.migrate(ctx); err != nil {
 		return nil, err
 	}
 
-	go func() {
-		for key := range watcher.Changes() {
-			if key == nil {
-				continue
-			}
-
-			switch key.Key {
-			case "users":
-				l.Trace().Msgf("Users key changed: %s", key.Value)
-
-				w.m.Lock()
-
-				if err := json.Unmarshal(key.Value, &w.Users); err != nil {
-					w.m.Unlock()
-					l.Error().Err(err).Msg("Failed to unmarshal users key")
-				}
-
-				w.m.Unlock()
-
-			case "groups":
-				l.Trace().Msgf("Groups key changed: %s", key.Value)
-
-				w.m.Lock()
-
-				if err := json.Unmarshal(key.Value, &w.Groups); err != nil {
-					w.m.Unlock()
-					l.Error().Err(err).Msg("Failed to unmarshal groups key")
-				}
-
-				w.m.Unlock()
-			}
+	for _, pattern := range []string{userKeyPrefix + ">", groupKeyPrefix + ">", defaultGroupKey} {
+		watcher, err := kv.WatchResuming(context.Background(), bucket, pattern)
+		if err != nil {
+			return nil, err
 		}
-	}()
+
+		go w.watch(watcher)
+	}
 
 	return w, nil
 }
 
-func (w *Permissions) Reload(ctx context.Context) error {
-	w.m.Lock()
-	defer w.m.Unlock()
+func (w *Permissions) watch(watcher kv.Watcher) {
+	for key := range watcher.Changes() {
+		if key == nil {
+			continue
+		}
 
-	if err := hosting.GetKeyFromKV(ctx, w.kv, "users", &w.Users); errors.Is(errors.Unwrap(err), kv.ErrKeyNotFound) {
-		w.Users = make(map[string]PermissionUser)
-	} else if err != nil {
-		return err
-	}
+		w.l.Trace().Msgf("Key %s changed: %s", key.Key, key.Value)
 
-	if err := hosting.GetKeyFromKV(ctx, w.kv, "groups", &w.Groups); errors.Is(errors.Unwrap(err), kv.ErrKeyNotFound) {
-		w.Groups = make(map[string]PermissionGroup)
-	} else if err != nil {
-		return err
+		if err := w.apply(key); err != nil {
+			w.l.Error().Err(err).Msgf("Failed to unmarshal %s key", key.Key)
+		}
 	}
+}
 
-	return nil
+func (w *Permissions) Reload(ctx context.Context) error {
+	return w.load(ctx)
 }
 
-func (w *Permissions) saveUsers(ctx context.Context) error {
-	w.m.Lock()
-	defer w.m.Unlock()
+// invalidate drops the cached effective permissions after groups changed.
+func (w *Permissions) invalidate() {
+	w.cacheM.Lock()
+	defer w.cacheM.Unlock()
 
-	return hosting.SetKeyToKV(ctx, w.kv, "users", w.Users)
+	w.generation++
+	clear(w.effective)
 }
 
-func (w *Permissions) saveGroups(ctx context.Context) error {
-	w.m.Lock()
-	defer w.m.Unlock()
+// invalidateUser drops the cached effective permissions of a user after it changed.
+func (w *Permissions) invalidateUser(uuid string) {
+	w.cacheM.Lock()
+	defer w.cacheM.Unlock()
 
-	return hosting.SetKeyToKV(ctx, w.kv, "groups", w.Groups)
+	w.generation++
+	delete(w.effective, uuid)
 }
 
 func (p *Permissions) GroupNames() []string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
 	return util.MapKeys(p.Groups)
 }
 
 func (p *Permissions) GetUsers() []string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
 	return util.MapKeys(p.Users)
 }
 
 func (p *Permissions) GetGroup(name string) (PermissionGroup, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
 	group, exists := p.Groups[name]
 	return group, exists
 }
 
 func (p *Permissions) UserPermissions(name string) ([]string, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
 	user, ok := p.Users[name]
 	if !ok {
 		return make([]string, 0), false
 	}
 
-	return user.Permissions, true
+	return slices.Clone(user.Permissions), true
 }
 
 func (p *Permissions) UserGroups(name string) ([]string, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
 	user, ok := p.Users[name]
 	if !ok {
 		return make([]string, 0), false
 	}
 
-	return user.Groups, true
+	return slices.Clone(user.Groups), true
+}
+
+// UserTemporary returns the temporary groups and permissions of a user with their expiry.
+func (p *Permissions) UserTemporary(name string) (groups map[string]time.Time, permissions map[string]time.Time) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	user := p.Users[name]
+
+	return maps.Clone(user.TemporaryGroups), maps.Clone(user.TemporaryPermissions)
 }
 
 func (p *Permissions) GroupHasPermission(name string, permission string) bool {
-	group, exists := p.GetGroup(name)
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	return p.groupHasPermission(name, permission)
+}
+
+// groupHasPermission is GroupHasPermission for callers holding p.m.
+func (p *Permissions) groupHasPermission(name string, permission string) bool {
+	group, exists := p.Groups[name]
 	if !exists {
 		p.l.Warn().Msgf("Group %s does not exist", name)
 		return false
 	}
 
-	for _, _permission := range group.Permissions {
-		if _permission == permission {
-			return true
-		}
+	return permnode.Check(group.Permissions, permission, nil) == permnode.Allow
+}
 
-		if strings.Split(_permission, ".")[0] == strings.Split(permission, ".")[0] && strings.Split(_permission, ".")[1] == "*" {
-			return true
-		}
+// UserHasEntry reports whether permission is set for a player exactly as given, permanently or temporarily.
+// Unlike UserPermission, it does not resolve wildcards, negations or scopes, so it tells which entries
+// can be removed.
+func (p *Permissions) UserHasEntry(player string, permission string) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	user := p.Users[uuid.Normalize(player)]
+	_, temporary := user.TemporaryPermissions[permission]
+
+	return temporary || slices.Contains(user.Permissions, permission)
+}
+
+// UserHasGroup reports whether a player is a member of a group, permanently or temporarily. Inherited
+// groups and the default group are not taken into account.
+func (p *Permissions) UserHasGroup(player string, group string) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	user := p.Users[uuid.Normalize(player)]
+	_, temporary := user.TemporaryGroups[group]
+
+	return temporary || slices.Contains(user.Groups, group)
+}
+
+// GroupHasEntry reports whether permission is set for a group exactly as given.
+func (p *Permissions) GroupHasEntry(name string, permission string) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	return slices.Contains(p.Groups[name].Permissions, permission)
+}
+
+// UserPermission resolves the permission of a player. Permissions of the user take precedence over the
+// ones of their groups, and groups with a higher weight take precedence over groups with a lower one.
+// Every player is a member of the default group and of all groups their groups inherit from. Permissions
+// scoped to a context only apply if ctx matches it.
+func (p *Permissions) UserPermission(player string, permission string, ctx permnode.Context) permnode.Result {
+	return permnode.CheckAll(permission, ctx, p.effectivePermissions(uuid.Normalize(player))...)
+}
+
+func (p *Permissions) effectivePermissions(player string) [][]string {
+	now := time.Now()
+
+	p.cacheM.Lock()
+	cached, exists := p.effective[player]
+	generation := p.generation
+	p.cacheM.Unlock()
+
+	// Temporary entries are evaluated lazily, so the cache is only valid until the next one expires
+	if exists && (cached.validUntil.IsZero() || now.Before(cached.validUntil)) {
+		return cached.sources
 	}
 
-	return false
+	p.m.RLock()
+
+	groups, permissions, next := p.Users[player].active(now)
+
+	sources := [][]string{permissions}
+	for _, name := range p.resolveGroups(append(groups, p.DefaultGroup)) {
+		sources = append(sources, p.Groups[name].Permissions)
+	}
+
+	p.m.RUnlock()
+
+	// Invalidations happen after the data changed, so if there was none since reading the generation, the
+	// result is not stale
+	p.cacheM.Lock()
+	if p.generation == generation {
+		p.effective[player] = effectivePermissions{sources: sources, validUntil: next}
+	}
+	p.cacheM.Unlock()
+
+	return sources
 }
 
-func (p *Permissions) UserHasPermission(player string, permission string) bool {
-	player = uuid.Normalize(player)
+// resolveGroups returns the existing groups out of names and all groups they inherit from, ordered by
+// descending weight, then by name. Parents forming a cycle are skipped.
+func (p *Permissions) resolveGroups(names []string) []string {
+	visited := make(map[string]bool)
 
-	user, ok := p.Users[player]
-	if !ok {
-		p.l.Warn().Msgf("User %s does not exist", player)
-		return false
+	var visit func(name string, path []string)
+	visit = func(name string, path []string) {
+		if slices.Contains(path, name) {
+			p.l.Warn().Msgf("Group %s inherits from itself through %s", name, strings.Join(path, " -> "))
+			return
+		}
+
+		if visited[name] {
+			return
+		}
+
+		group, exists := p.Groups[name]
+		if !exists {
+			// The default group is optional
+			if name != p.DefaultGroup {
+				p.l.Warn().Msgf("Group %s does not exist", name)
+			}
+			return
+		}
+
+		visited[name] = true
+
+		for _, parent := range group.Parents {
+			visit(parent, append(path, name))
+		}
 	}
 
-	for _, userPermission := range user.Permissions {
-		if userPermission == permission {
-			return true
+	for _, name := range names {
+		visit(name, nil)
+	}
+
+	groups := util.MapKeys(visited)
+
+	slices.SortFunc(groups, func(a, b string) int {
+		if wa, wb := p.Groups[a].Weight, p.Groups[b].Weight; wa != wb {
+			return int(wb) - int(wa)
 		}
 
-		if strings.Split(userPermission, ".")[0] == strings.Split(permission, ".")[0] && strings.Split(userPermission, ".")[1] == "*" {
+		return strings.Compare(a, b)
+	})
+
+	return groups
+}
+
+// checkParents returns ErrGroupCycle if making parents the parents of the group name creates a cycle.
+func (p *Permissions) checkParents(name string, parents []string) error {
+	var reaches func(from string, visited map[string]bool) bool
+	reaches = func(from string, visited map[string]bool) bool {
+		if from == name {
 			return true
 		}
+
+		if visited[from] {
+			return false
+		}
+		visited[from] = true
+
+		return slices.ContainsFunc(p.Groups[from].Parents, func(parent string) bool {
+			return reaches(parent, visited)
+		})
 	}
 
-	for _, userGroup := range user.Groups {
-		if p.GroupHasPermission(userGroup, permission) {
-			return true
+	visited := make(map[string]bool)
+	for _, parent := range parents {
+		if reaches(parent, visited) {
+			return fmt.Errorf("%w: %s inherits from %s", ErrGroupCycle, parent, name)
 		}
 	}
 
-	return false
+	return nil
 }
 
-func (p *Permissions) UserAddPermission(ctx context.Context, UUID string, permission string) error {
-	p.m.Lock()
-	UUID = uuid.Normalize(UUID)
-	user := p.Users[UUID]
-	user.Permissions = append(user.Permissions, permission)
-	p.Users[UUID] = user
-	p.m.Unlock()
+// GroupSetParents replaces the parents of a group, unless that creates a cycle.
+func (p *Permissions) GroupSetParents(ctx context.Context, name string, parents []string) error {
+	p.m.RLock()
+	err := p.checkParents(name, parents)
+	p.m.RUnlock()
+
+	if err != nil {
+		return err
+	}
 
-	return p.saveUsers(ctx)
+	return p.updateGroup(ctx, name, func(group *PermissionGroup) error {
+		group.Parents = parents
+		return nil
+	})
 }
 
-func (p *Permissions) GroupAddPermission(ctx context.Context, name string, permission string) error {
-	p.m.Lock()
-	group := p.Groups[name]
-	group.Permissions = append(group.Permissions, permission)
-	p.Groups[name] = group
-	p.m.Unlock()
+func (p *Permissions) UserAddPermission(ctx context.Context, UUID string, permission string) error {
+	return p.updateUser(ctx, uuid.Normalize(UUID), func(user *PermissionUser) error {
+		user.Permissions = append(user.Permissions, permission)
+		return nil
+	})
+}
 
-	return p.saveGroups(ctx)
+// UserAddTemporaryPermission grants a permission to a player until it expires after d. Granting it again
+// replaces the expiry.
+func (p *Permissions) UserAddTemporaryPermission(ctx context.Context, UUID string, permission string, d time.Duration) error {
+	return p.updateUser(ctx, uuid.Normalize(UUID), func(user *PermissionUser) error {
+		if user.TemporaryPermissions == nil {
+			user.TemporaryPermissions = make(map[string]time.Time)
+		}
+		user.TemporaryPermissions[permission] = time.Now().Add(d)
+		return nil
+	})
 }
 
-func (p *Permissions) UserRemovePermission(ctx context.Context, UUID string, permission string) error {
-	p.m.Lock()
-	UUID = uuid.Normalize(UUID)
-	user := p.Users[UUID]
+func (p *Permissions) UserAddGroup(ctx context.Context, UUID string, group string) error {
+	return p.updateUser(ctx, uuid.Normalize(UUID), func(user *PermissionUser) error {
+		if !slices.Contains(user.Groups, group) {
+			user.Groups = append(user.Groups, group)
+		}
+		return nil
+	})
+}
 
-	user.Permissions = slices.DeleteFunc(user.Permissions, func(s string) bool {
-		return s == permission
+// UserRemoveGroup ends the permanent and temporary membership of a player in a group.
+func (p *Permissions) UserRemoveGroup(ctx context.Context, UUID string, group string) error {
+	return p.updateUser(ctx, uuid.Normalize(UUID), func(user *PermissionUser) error {
+		user.Groups = slices.DeleteFunc(user.Groups, func(s string) bool {
+			return s == group
+		})
+		delete(user.TemporaryGroups, group)
+		return nil
 	})
+}
+
+// UserAddTemporaryGroup makes a player a member of a group until the membership expires after d. Adding
+// them again replaces the expiry.
+func (p *Permissions) UserAddTemporaryGroup(ctx context.Context, UUID string, group string, d time.Duration) error {
+	return p.updateUser(ctx, uuid.Normalize(UUID), func(user *PermissionUser) error {
+		if user.TemporaryGroups == nil {
+			user.TemporaryGroups = make(map[string]time.Time)
+		}
+		user.TemporaryGroups[group] = time.Now().Add(d)
+		return nil
+	})
+}
 
-	p.Users[UUID] = user
-	p.m.Unlock()
+// ExpiringBetween returns the temporary entries of all players that expire after from and until to.
+func (p *Permissions) ExpiringBetween(from, to time.Time) []Expiry {
+	p.m.RLock()
+	defer p.m.RUnlock()
 
-	return p.saveUsers(ctx)
+	var expired []Expiry
+	for player, user := range p.Users {
+		for group, expiresAt := range user.TemporaryGroups {
+			if expiresAt.After(from) && !expiresAt.After(to) {
+				expired = append(expired, Expiry{Player: player, Group: group, ExpiresAt: expiresAt})
+			}
+		}
+
+		for permission, expiresAt := range user.TemporaryPermissions {
+			if expiresAt.After(from) && !expiresAt.After(to) {
+				expired = append(expired, Expiry{Player: player, Permission: permission, ExpiresAt: expiresAt})
+			}
+		}
+	}
+
+	return expired
 }
 
-func (p *Permissions) GroupRemovePermission(ctx context.Context, name string, permission string) error {
-	p.m.Lock()
-	group := p.Groups[name]
+// Sweep removes the temporary entries that expired at before and saves the users they were removed from.
+func (p *Permissions) Sweep(ctx context.Context, before time.Time) ([]Expiry, error) {
+	p.m.RLock()
+	var players []string
+	for player, user := range p.Users {
+		if user.hasExpired(before) {
+			players = append(players, player)
+		}
+	}
+	p.m.RUnlock()
 
-	group.Permissions = slices.DeleteFunc(group.Permissions, func(s string) bool {
-		return s == permission
+	var expired []Expiry
+	for _, player := range players {
+		var removed []Expiry
+
+		err := p.updateUser(ctx, player, func(user *PermissionUser) error {
+			// The entry may have been changed since, so only what is expired now is removed
+			removed = nil
+
+			for group, expiresAt := range user.TemporaryGroups {
+				if !expiresAt.After(before) {
+					delete(user.TemporaryGroups, group)
+					removed = append(removed, Expiry{Player: player, Group: group, ExpiresAt: expiresAt})
+				}
+			}
+
+			for permission, expiresAt := range user.TemporaryPermissions {
+				if !expiresAt.After(before) {
+					delete(user.TemporaryPermissions, permission)
+					removed = append(removed, Expiry{Player: player, Permission: permission, ExpiresAt: expiresAt})
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return expired, err
+		}
+
+		expired = append(expired, removed...)
+	}
+
+	return expired, nil
+}
+
+func (p *Permissions) GroupAddPermission(ctx context.Context, name string, permission string) error {
+	return p.updateGroup(ctx, name, func(group *PermissionGroup) error {
+		group.Permissions = append(group.Permissions, permission)
+		return nil
 	})
+}
 
-	p.Groups[name] = group
-	p.m.Unlock()
+// UserRemovePermission removes the permanent and temporary entries of a permission from a player.
+func (p *Permissions) UserRemovePermission(ctx context.Context, UUID string, permission string) error {
+	return p.updateUser(ctx, uuid.Normalize(UUID), func(user *PermissionUser) error {
+		user.Permissions = slices.DeleteFunc(user.Permissions, func(s string) bool {
+			return s == permission
+		})
+		delete(user.TemporaryPermissions, permission)
+		return nil
+	})
+}
 
-	return p.saveGroups(ctx)
+func (p *Permissions) GroupRemovePermission(ctx context.Context, name string, permission string) error {
+	return p.updateGroup(ctx, name, func(group *PermissionGroup) error {
+		group.Permissions = slices.DeleteFunc(group.Permissions, func(s string) bool {
+			return s == permission
+		})
+		return nil
+	})
 }
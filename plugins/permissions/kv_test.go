@@ -0,0 +1,530 @@
+package permissions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/storage"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/permnode"
+	"github.com/rs/zerolog"
+)
+
+func newTestPermissions(t *testing.T) *Permissions {
+	t.Helper()
+
+	ctx := context.Background()
+
+	k, err := kv.NewJSONClient(storage.NewMemory(), "permissions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { k.Close(ctx) })
+
+	bucket, err := k.Bucket(ctx, "permissions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Permissions{
+		Users:        make(map[string]PermissionUser),
+		Groups:       make(map[string]PermissionGroup),
+		DefaultGroup: DefaultGroup,
+		revisions:    make(map[string]uint64),
+		effective:    make(map[string]effectivePermissions),
+		kv:           bucket,
+		l:            zerolog.Nop(),
+	}
+}
+
+func TestUserRemoveEntry(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f4-44e9-4726-a5be-fca90e38aaf5"
+	minigames := permnode.Context{permnode.ContextGamemode: "minigames"}
+
+	tests := []struct {
+		entry string
+		node  string
+		ctx   permnode.Context
+		want  permnode.Result
+	}{
+		{"kick[gamemode=minigames]", "kick", minigames, permnode.Allow},
+		{"-fly", "fly", nil, permnode.Deny},
+	}
+
+	for _, test := range tests {
+		p := newTestPermissions(t)
+
+		if err := p.UserAddPermission(ctx, player, test.entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if !p.UserHasEntry(player, test.entry) {
+			t.Fatalf("expected entry %s to be set", test.entry)
+		}
+
+		if result := p.UserPermission(player, test.node, test.ctx); result != test.want {
+			t.Fatalf("expected %s to be %s with entry %s, got %s", test.node, test.want, test.entry, result)
+		}
+
+		if err := p.UserRemovePermission(ctx, player, test.entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.UserHasEntry(player, test.entry) {
+			t.Fatalf("expected entry %s to be removed", test.entry)
+		}
+
+		if result := p.UserPermission(player, test.node, test.ctx); result != permnode.Undefined {
+			t.Fatalf("expected %s to be undefined after removing %s, got %s", test.node, test.entry, result)
+		}
+	}
+}
+
+func TestUserRemoveTemporary(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f4-44e9-4726-a5be-fca90e38aaf5"
+
+	p := newTestPermissions(t)
+
+	if err := p.UserAddTemporaryPermission(ctx, player, "fly", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UserAddTemporaryGroup(ctx, player, "vip", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.UserHasEntry(player, "fly") || !p.UserHasGroup(player, "vip") {
+		t.Fatal("expected the temporary entries to be set")
+	}
+
+	if err := p.UserRemovePermission(ctx, player, "fly"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UserRemoveGroup(ctx, player, "vip"); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.UserHasEntry(player, "fly") || p.UserHasGroup(player, "vip") {
+		t.Fatal("expected the temporary entries to be removed")
+	}
+
+	if result := p.UserPermission(player, "fly", nil); result != permnode.Undefined {
+		t.Fatalf("expected fly to be undefined after removing it, got %s", result)
+	}
+}
+
+func TestUpdateKeepsNewerChanges(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f444e94726a5befca90e38aaf5"
+
+	p := newTestPermissions(t)
+
+	// The watcher applied a change written after the one below, before the writer cached its result
+	if err := p.apply(&kv.Value{
+		Key:      userKey(player),
+		Value:    []byte(`{"permissions":["fly","kick"]}`),
+		Revision: 100,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UserAddPermission(ctx, player, "fly"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.UserHasEntry(player, "kick") {
+		t.Fatal("expected the newer change of the watcher to be kept")
+	}
+
+	// Without a newer change, the result of a write is cached right away
+	if err := p.UserAddPermission(ctx, "d8d5a9237b2043d8883b1150148d6955", "fly"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.UserHasEntry("d8d5a9237b2043d8883b1150148d6955", "fly") {
+		t.Fatal("expected the written user to be cached")
+	}
+}
+
+// setGroups writes groups to the bucket and reloads them, as another proxy would have written them.
+func setGroups(t *testing.T, p *Permissions, groups map[string]PermissionGroup) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	for name, group := range groups {
+		raw, err := json.Marshal(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.kv.Set(ctx, groupKey(name), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupInheritance(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f444e94726a5befca90e38aaf5"
+
+	p := newTestPermissions(t)
+	setGroups(t, p, map[string]PermissionGroup{
+		"member":    {Permissions: []string{"chat"}},
+		"vip":       {Permissions: []string{"fly"}, Parents: []string{"member"}},
+		"moderator": {Permissions: []string{"kick"}, Parents: []string{"vip"}},
+	})
+
+	if err := p.UserAddGroup(ctx, player, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range []string{"kick", "fly", "chat"} {
+		if result := p.UserPermission(player, node, nil); result != permnode.Allow {
+			t.Errorf("expected %s to be inherited, got %s", node, result)
+		}
+	}
+
+	if result := p.UserPermission("d8d5a9237b2043d8883b1150148d6955", "chat", nil); result != permnode.Undefined {
+		t.Errorf("expected chat to be undefined for players outside of the groups, got %s", result)
+	}
+}
+
+func TestGroupCycle(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f444e94726a5befca90e38aaf5"
+
+	p := newTestPermissions(t)
+	setGroups(t, p, map[string]PermissionGroup{
+		"a": {Permissions: []string{"a"}, Parents: []string{"b"}},
+		"b": {Permissions: []string{"b"}, Parents: []string{"c"}},
+		"c": {Permissions: []string{"c"}},
+	})
+
+	if err := p.GroupSetParents(ctx, "c", []string{"a"}); !errors.Is(err, ErrGroupCycle) {
+		t.Fatalf("expected ErrGroupCycle, got %v", err)
+	}
+
+	if err := p.GroupSetParents(ctx, "c", []string{"c"}); !errors.Is(err, ErrGroupCycle) {
+		t.Fatalf("expected ErrGroupCycle for a group inheriting from itself, got %v", err)
+	}
+
+	// Cycles written by other means are skipped when resolving
+	setGroups(t, p, map[string]PermissionGroup{
+		"c": {Permissions: []string{"c"}, Parents: []string{"a"}},
+	})
+
+	if err := p.UserAddGroup(ctx, player, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range []string{"a", "b", "c"} {
+		if result := p.UserPermission(player, node, nil); result != permnode.Allow {
+			t.Errorf("expected %s to be resolved despite the cycle, got %s", node, result)
+		}
+	}
+}
+
+func TestDefaultGroup(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f444e94726a5befca90e38aaf5"
+
+	p := newTestPermissions(t)
+	setGroups(t, p, map[string]PermissionGroup{
+		DefaultGroup: {Permissions: []string{"help"}},
+		"member":     {Permissions: []string{"chat"}},
+	})
+
+	if result := p.UserPermission(player, "help", nil); result != permnode.Allow {
+		t.Fatalf("expected every player to be a member of the default group, got %s", result)
+	}
+
+	if err := p.kv.Set(ctx, defaultGroupKey, []byte(`"member"`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := p.UserPermission(player, "chat", nil); result != permnode.Allow {
+		t.Errorf("expected every player to be a member of the configured default group, got %s", result)
+	}
+
+	if result := p.UserPermission(player, "help", nil); result != permnode.Undefined {
+		t.Errorf("expected the previous default group not to apply anymore, got %s", result)
+	}
+}
+
+func TestGroupWeight(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f444e94726a5befca90e38aaf5"
+
+	p := newTestPermissions(t)
+
+	if err := p.UserAddGroup(ctx, player, "muted"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UserAddGroup(ctx, player, "member"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		muted  uint8
+		member uint8
+		want   permnode.Result
+	}{
+		{10, 1, permnode.Deny},
+		{1, 10, permnode.Allow},
+	}
+
+	for _, test := range tests {
+		setGroups(t, p, map[string]PermissionGroup{
+			"muted":  {Permissions: []string{"-chat"}, Weight: test.muted},
+			"member": {Permissions: []string{"chat"}, Weight: test.member},
+		})
+
+		if result := p.UserPermission(player, "chat", nil); result != test.want {
+			t.Errorf("expected chat to be %s with weights %d and %d, got %s", test.want, test.muted, test.member, result)
+		}
+	}
+
+	// Permissions of the user take precedence over all groups
+	if err := p.UserAddPermission(ctx, player, "-chat"); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := p.UserPermission(player, "chat", nil); result != permnode.Deny {
+		t.Errorf("expected the permission of the user to take precedence, got %s", result)
+	}
+}
+
+func TestTemporaryEntriesExpire(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f444e94726a5befca90e38aaf5"
+
+	p := newTestPermissions(t)
+	setGroups(t, p, map[string]PermissionGroup{
+		"vip": {Permissions: []string{"kick"}},
+	})
+
+	if err := p.UserAddTemporaryPermission(ctx, player, "fly", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UserAddTemporaryGroup(ctx, player, "vip", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UserAddTemporaryPermission(ctx, player, "chat", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resolving caches the effective permissions, which must not outlive the temporary entries
+	for _, node := range []string{"fly", "kick", "chat"} {
+		if result := p.UserPermission(player, node, nil); result != permnode.Allow {
+			t.Fatalf("expected %s to be granted temporarily, got %s", node, result)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, node := range []string{"fly", "kick"} {
+		if result := p.UserPermission(player, node, nil); result != permnode.Undefined {
+			t.Errorf("expected %s to expire, got %s", node, result)
+		}
+	}
+
+	if result := p.UserPermission(player, "chat", nil); result != permnode.Allow {
+		t.Errorf("expected chat to be granted until it expires, got %s", result)
+	}
+
+	// Expired entries are only evaluated lazily until they are swept
+	if !p.UserHasEntry(player, "fly") || !p.UserHasGroup(player, "vip") {
+		t.Fatal("expected the expired entries to be kept until they are swept")
+	}
+
+	expired, err := p.Sweep(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 2 {
+		t.Fatalf("expected 2 expired entries, got %+v", expired)
+	}
+
+	for _, expiry := range expired {
+		if expiry.Player != player || (expiry.Permission != "fly" && expiry.Group != "vip") {
+			t.Errorf("unexpected expired entry %+v", expiry)
+		}
+	}
+
+	if p.UserHasEntry(player, "fly") || p.UserHasGroup(player, "vip") {
+		t.Error("expected the expired entries to be removed")
+	}
+
+	if !p.UserHasEntry(player, "chat") {
+		t.Error("expected the entry that did not expire to be kept")
+	}
+
+	// The removal is saved, so other proxies don't see the entries anymore either
+	other := newTestPermissions(t)
+	other.kv = p.kv
+	if err := other.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if other.UserHasEntry(player, "fly") || other.UserHasGroup(player, "vip") {
+		t.Error("expected the removal of the expired entries to be saved")
+	}
+
+	if expired, err := p.Sweep(ctx, time.Now()); err != nil || len(expired) != 0 {
+		t.Errorf("expected nothing left to sweep, got %+v and %v", expired, err)
+	}
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		migrated = "069a79f444e94726a5befca90e38aaf5"
+		existing = "853c80ef3c3749fdaa49938b674adae6"
+	)
+
+	p := newTestPermissions(t)
+
+	users, err := json.Marshal(map[string]PermissionUser{
+		migrated: {Permissions: []string{"fly"}},
+		existing: {Permissions: []string{"legacy"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := json.Marshal(map[string]PermissionGroup{
+		"vip": {Permissions: []string{"kick"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.kv.Set(ctx, legacyUsersKey, users); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.kv.Set(ctx, legacyGroupsKey, groups); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries already stored under their own key are newer than the legacy ones
+	if err := p.UserAddPermission(ctx, existing, "current"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.UserHasEntry(migrated, "fly") {
+		t.Error("expected the legacy user to be migrated")
+	}
+
+	if p.UserHasEntry(existing, "legacy") || !p.UserHasEntry(existing, "current") {
+		t.Error("expected the user stored under its own key to be kept")
+	}
+
+	if _, ok := p.Groups["vip"]; !ok {
+		t.Error("expected the legacy group to be migrated")
+	}
+
+	for _, key := range []string{userKey(migrated), groupKey("vip")} {
+		if _, err := p.kv.Get(ctx, key); err != nil {
+			t.Errorf("expected %s to be stored under its own key: %v", key, err)
+		}
+	}
+
+	for _, key := range []string{legacyUsersKey, legacyGroupsKey} {
+		if _, err := p.kv.Get(ctx, key); !errors.Is(err, kv.ErrKeyNotFound) {
+			t.Errorf("expected the legacy %s key to be deleted, got %v", key, err)
+		}
+	}
+}
+
+func TestUpdateRetriesOnConflict(t *testing.T) {
+	ctx := context.Background()
+
+	const player = "069a79f444e94726a5befca90e38aaf5"
+
+	p := newTestPermissions(t)
+
+	if err := p.UserAddPermission(ctx, player, "fly"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another proxy writes the entry between reading and writing it, so the first attempt fails
+	attempts := 0
+	user, _, err := update(ctx, p.kv, userKey(player), func(user *PermissionUser) error {
+		attempts++
+		if attempts == 1 {
+			raw, err := json.Marshal(PermissionUser{Permissions: []string{"fly", "chat"}})
+			if err != nil {
+				return err
+			}
+
+			if err := p.kv.Set(ctx, userKey(player), raw); err != nil {
+				return err
+			}
+		}
+
+		user.Permissions = append(user.Permissions, "kick")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 2 {
+		t.Fatalf("expected the update to be applied twice, got %d attempts", attempts)
+	}
+
+	// The retry is applied to the concurrently written entry, so its change is not lost
+	if want := []string{"fly", "chat", "kick"}; !slices.Equal(user.Permissions, want) {
+		t.Fatalf("expected permissions %v, got %v", want, user.Permissions)
+	}
+
+	// An entry that keeps changing gives up eventually
+	attempts = 0
+	_, _, err = update(ctx, p.kv, userKey(player), func(user *PermissionUser) error {
+		attempts++
+
+		return p.kv.Set(ctx, userKey(player), []byte("{}"))
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	if attempts != maxUpdateAttempts {
+		t.Fatalf("expected %d attempts, got %d", maxUpdateAttempts, attempts)
+	}
+}
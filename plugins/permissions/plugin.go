@@ -3,8 +3,13 @@ package permissions
 import (
 	"context"
 	"fmt"
+	"slices"
+	"time"
 
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/permnode"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/util/uuid"
+	"github.com/robinbraemer/event"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"go.minekube.com/brigodier"
@@ -12,32 +17,143 @@ import (
 	"go.minekube.com/common/minecraft/component"
 	"go.minekube.com/gate/pkg/command"
 	"go.minekube.com/gate/pkg/edition/java/proxy"
+	"go.minekube.com/gate/pkg/util/permission"
 )
 
 type PermissionsPlugin struct {
 	prx         *proxy.Proxy
+	mgr         *hosting.InstanceManager
 	permissions *Permissions
 	l           zerolog.Logger
 }
 
-func NewPlugin(prx *proxy.Proxy, permissions *Permissions) (*PermissionsPlugin, error) {
+func NewPlugin(prx *proxy.Proxy, mgr *hosting.InstanceManager, permissions *Permissions) (*PermissionsPlugin, error) {
 	return &PermissionsPlugin{
 		prx:         prx,
+		mgr:         mgr,
 		permissions: permissions,
 		l:           log.With().Str("plugin", "permissions").Logger(),
 	}, nil
 }
 
-func (p *PermissionsPlugin) Init() error {
+// ExpirySweepDelay is how long expired temporary entries are kept, so every proxy notifies its players
+// before they are removed.
+const ExpirySweepDelay = time.Minute
+
+func (p *PermissionsPlugin) Init(ctx context.Context) error {
 	if err := p.Reload(); err != nil {
 		return err
 	}
 
 	p.prx.Command().Register(p.command())
+	event.Subscribe(p.prx.Event(), 0, p.onPermissionsSetup)
+
+	go p.runExpiry(ctx)
 
 	return nil
 }
 
+// runExpiry notifies players of this proxy when their temporary entries expire and removes expired entries
+// until ctx is cancelled. Checks evaluate expiry lazily, so removing them only keeps the data clean.
+func (p *PermissionsPlugin) runExpiry(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		for _, expiry := range p.permissions.ExpiringBetween(last, now) {
+			p.notifyExpiry(expiry)
+		}
+		last = now
+
+		expired, err := p.permissions.Sweep(ctx, now.Add(-ExpirySweepDelay))
+		if err != nil {
+			p.l.Error().Err(err).Msg("Failed to remove expired permissions")
+			continue
+		}
+
+		for _, expiry := range expired {
+			p.l.Debug().Msgf("Removed expired entry %+v", expiry)
+		}
+	}
+}
+
+func (p *PermissionsPlugin) notifyExpiry(expiry Expiry) {
+	for _, player := range p.prx.Players() {
+		if uuid.Normalize(player.ID().String()) != expiry.Player {
+			continue
+		}
+
+		what := "permission " + expiry.Permission
+		if expiry.Group != "" {
+			what = "membership of group " + expiry.Group
+		}
+
+		if err := player.SendMessage(&component.Text{
+			Extra: []component.Component{
+				&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
+				&component.Text{Content: "Your " + what + " expired.", S: component.Style{Color: color.Yellow}},
+			},
+		}); err != nil {
+			p.l.Error().Err(err).Msgf("Failed to notify %s about expired %s", player.Username(), what)
+		}
+	}
+}
+
+// onPermissionsSetup makes Gate use the network permissions for every HasPermission check of a player.
+// Permissions neither granted nor denied by the network fall back to the previous function.
+func (p *PermissionsPlugin) onPermissionsSetup(e *proxy.PermissionsSetupEvent) {
+	player, ok := e.Subject().(proxy.Player)
+	if !ok {
+		return
+	}
+
+	id := player.ID().String()
+	fallback := e.Func()
+
+	e.SetFunc(func(node string) permission.TriState {
+		switch p.permissions.UserPermission(id, node, p.context(player)) {
+		case permnode.Allow:
+			return permission.True
+		case permnode.Deny:
+			return permission.False
+		}
+
+		if fallback == nil {
+			return permission.Undefined
+		}
+
+		return fallback(node)
+	})
+}
+
+// context returns where the player currently is, so permissions scoped to a server, gamemode or proxy
+// apply.
+func (p *PermissionsPlugin) context(player proxy.Player) permnode.Context {
+	ctx := permnode.Context{permnode.ContextProxy: p.permissions.h.Info.PodName}
+
+	conn := player.CurrentServer()
+	if conn == nil {
+		return ctx
+	}
+
+	name := conn.Server().ServerInfo().Name()
+	ctx[permnode.ContextServer] = name
+
+	if info, ok := p.mgr.Instance(name); ok && info.Gamemode != "" {
+		ctx[permnode.ContextGamemode] = info.Gamemode
+	}
+
+	return ctx
+}
+
 func (p *PermissionsPlugin) Reload() error {
 	if err := p.permissions.Reload(context.Background()); err != nil {
 		return err
@@ -50,12 +166,17 @@ func New(permissions *Permissions) (proxy.Plugin, error) {
 	return proxy.Plugin{
 		Name: "Permissions",
 		Init: func(ctx context.Context, prx *proxy.Proxy) error {
-			plugin, err := NewPlugin(prx, permissions)
+			mgr, err := permissions.h.InstanceManager(ctx, prx)
 			if err != nil {
 				return err
 			}
 
-			return plugin.Init()
+			plugin, err := NewPlugin(prx, mgr, permissions)
+			if err != nil {
+				return err
+			}
+
+			return plugin.Init(ctx)
 		},
 	}, nil
 }
@@ -76,7 +197,12 @@ func (p *PermissionsPlugin) command() brigodier.LiteralNodeBuilder {
 				Then(brigodier.Literal("info").
 					Executes(p.InfoCommand(PermissionTypeUser))).
 				Then(brigodier.Literal("remove").Then(brigodier.Argument("permission", brigodier.String).Executes(p.removeCommand(PermissionTypeUser)))).
-				Then(brigodier.Literal("add").Then(brigodier.Argument("permission", brigodier.String).Executes(p.addCommand(PermissionTypeUser)))),
+				Then(brigodier.Literal("add").Then(brigodier.Argument("permission", brigodier.String).Executes(p.addCommand(PermissionTypeUser)).
+					Then(brigodier.Argument("duration", brigodier.String).Executes(p.addCommand(PermissionTypeUser))))).
+				Then(brigodier.Literal("group").
+					Then(brigodier.Literal("add").Then(brigodier.Argument("group", brigodier.String).Executes(p.userGroupAddCommand()).
+						Then(brigodier.Argument("duration", brigodier.String).Executes(p.userGroupAddCommand())))).
+					Then(brigodier.Literal("remove").Then(brigodier.Argument("group", brigodier.String).Executes(p.userGroupRemoveCommand())))),
 			),
 		).
 		Then(brigodier.
@@ -106,7 +232,7 @@ const (
 
 func (p *PermissionsPlugin) InfoCommand(_type PermissionListType) brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "permissions.info") {
+		if !c.Source.HasPermission("permissions.info") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		name := c.String("name")
@@ -149,6 +275,19 @@ func (p *PermissionsPlugin) InfoCommand(_type PermissionListType) brigodier.Comm
 				}
 			}
 
+			temporaryGroups, temporaryPermissions := p.permissions.UserTemporary(UUID)
+			for group, expiresAt := range temporaryGroups {
+				groupsMsg = append(groupsMsg, &component.Text{Content: "\n > ", S: component.Style{Color: color.Yellow}}, &component.Text{Content: group + expiresIn(expiresAt), S: component.Style{Color: color.White}})
+			}
+
+			if len(temporaryPermissions) != 0 {
+				permissionMsg = append(permissionMsg, &component.Text{Content: "\nTemporary permissions: ", S: component.Style{Color: color.Yellow}})
+
+				for permission, expiresAt := range temporaryPermissions {
+					permissionMsg = append(permissionMsg, &component.Text{Content: "\n > ", S: component.Style{Color: color.Yellow}}, &component.Text{Content: permission + expiresIn(expiresAt), S: component.Style{Color: color.White}})
+				}
+			}
+
 			return c.SendMessage(&component.Text{
 				Extra: []component.Component{
 					&component.Text{Content: "\n"},
@@ -206,9 +345,18 @@ func (p *PermissionsPlugin) InfoCommand(_type PermissionListType) brigodier.Comm
 	})
 }
 
+func expiresIn(expiresAt time.Time) string {
+	remaining := time.Until(expiresAt).Round(time.Second)
+	if remaining <= 0 {
+		return " (expired)"
+	}
+
+	return " (expires in " + remaining.String() + ")"
+}
+
 func (p *PermissionsPlugin) helpCommand() brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "permissions.help") {
+		if !c.Source.HasPermission("permissions.help") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		return c.SendMessage(&component.Text{
@@ -229,7 +377,7 @@ func (p *PermissionsPlugin) helpCommand() brigodier.Command {
 
 func (p *PermissionsPlugin) addCommand(_type PermissionListType) brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "permissions.add") {
+		if !c.Source.HasPermission("permissions.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 
@@ -254,19 +402,46 @@ func (p *PermissionsPlugin) addCommand(_type PermissionListType) brigodier.Comma
 			}
 
 			UUID = uuid.Normalize(UUID)
-			res := p.permissions.UserHasPermission(UUID, permission)
-			if res {
+
+			// Temporary permissions may be extended, so only permanent ones are checked for duplicates
+			if arg, ok := c.Arguments["duration"]; ok {
+				duration, err := time.ParseDuration(arg.Result.(string))
+				if err != nil || duration <= 0 {
+					return c.SendMessage(&component.Text{Content: "Invalid duration, use e.g. 30m or 2h", S: component.Style{Color: color.Red}})
+				}
+
+				if err := p.permissions.UserAddTemporaryPermission(c.Context, UUID, permission, duration); err != nil {
+					return err
+				}
+
+				return c.SendMessage(&component.Text{
+					Extra: []component.Component{
+						&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
+						&component.Text{Content: "Set ", S: component.Style{Color: color.Green}},
+						&component.Text{Content: permission, S: component.Style{Color: color.LightPurple}},
+						&component.Text{Content: " for ", S: component.Style{Color: color.Green}},
+						&component.Text{Content: name, S: component.Style{Color: color.LightPurple}},
+						&component.Text{Content: " for " + duration.String(), S: component.Style{Color: color.Green}},
+					},
+				})
+			}
+
+			// A temporary entry may be made permanent, so only permanent ones are duplicates
+			if permissions, _ := p.permissions.UserPermissions(UUID); slices.Contains(permissions, permission) {
 				return c.SendMessage(errorMsg)
 			}
 
-			p.permissions.UserAddPermission(c.Context, UUID, permission)
+			if err := p.permissions.UserAddPermission(c.Context, UUID, permission); err != nil {
+				return err
+			}
 		case PermissionTypeGroup:
-			res := p.permissions.GroupHasPermission(name, permission)
-			if res {
+			if p.permissions.GroupHasEntry(name, permission) {
 				return c.SendMessage(errorMsg)
 			}
 
-			p.permissions.GroupAddPermission(c.Context, name, permission)
+			if err := p.permissions.GroupAddPermission(c.Context, name, permission); err != nil {
+				return err
+			}
 		}
 
 		return c.SendMessage(&component.Text{
@@ -283,7 +458,7 @@ func (p *PermissionsPlugin) addCommand(_type PermissionListType) brigodier.Comma
 
 func (p *PermissionsPlugin) removeCommand(_type PermissionListType) brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "permissions.remove") {
+		if !c.Source.HasPermission("permissions.remove") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 
@@ -295,7 +470,7 @@ func (p *PermissionsPlugin) removeCommand(_type PermissionListType) brigodier.Co
 				&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
 				&component.Text{Content: "Permission ", S: component.Style{Color: color.Red}},
 				&component.Text{Content: permission, S: component.Style{Color: color.LightPurple}},
-				&component.Text{Content: " doesn't exists for ", S: component.Style{Color: color.Red}},
+				&component.Text{Content: " is not set for ", S: component.Style{Color: color.Red}},
 				&component.Text{Content: name, S: component.Style{Color: color.LightPurple}},
 			},
 		}
@@ -308,19 +483,23 @@ func (p *PermissionsPlugin) removeCommand(_type PermissionListType) brigodier.Co
 			}
 
 			UUID = uuid.Normalize(UUID)
-			res := p.permissions.UserHasPermission(UUID, permission)
-			if !res {
+
+			// Entries are matched literally, so negated and scoped entries can be removed as well
+			if !p.permissions.UserHasEntry(UUID, permission) {
 				return c.SendMessage(errorMsg)
 			}
 
-			p.permissions.UserRemovePermission(c.Context, UUID, permission)
+			if err := p.permissions.UserRemovePermission(c.Context, UUID, permission); err != nil {
+				return err
+			}
 		case PermissionTypeGroup:
-			res := p.permissions.GroupHasPermission(name, permission)
-			if !res {
+			if !p.permissions.GroupHasEntry(name, permission) {
 				return c.SendMessage(errorMsg)
 			}
 
-			p.permissions.GroupRemovePermission(c.Context, name, permission)
+			if err := p.permissions.GroupRemovePermission(c.Context, name, permission); err != nil {
+				return err
+			}
 		}
 
 		return c.SendMessage(&component.Text{
@@ -335,9 +514,123 @@ func (p *PermissionsPlugin) removeCommand(_type PermissionListType) brigodier.Co
 	})
 }
 
+// userGroupAddCommand makes a player a member of a group, temporarily if a duration is given, like for
+// rank purchases.
+func (p *PermissionsPlugin) userGroupAddCommand() brigodier.Command {
+	return command.Command(func(c *command.Context) error {
+		if !c.Source.HasPermission("permissions.add") {
+			return PermissionMissingCommand().Run(c.CommandContext)
+		}
+
+		group := c.Arguments["group"].Result.(string)
+		name := c.Arguments["name"].Result.(string)
+
+		if _, exists := p.permissions.GetGroup(group); !exists {
+			return c.SendMessage(&component.Text{
+				Extra: []component.Component{
+					&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
+					&component.Text{Content: "This group doesn't exist!", S: component.Style{Color: color.Red}},
+				},
+			})
+		}
+
+		UUID, err := uuid.UsernameToUUID(name)
+		if err != nil {
+			return err
+		}
+
+		UUID = uuid.Normalize(UUID)
+
+		suffix := ""
+		if arg, ok := c.Arguments["duration"]; ok {
+			duration, err := time.ParseDuration(arg.Result.(string))
+			if err != nil || duration <= 0 {
+				return c.SendMessage(&component.Text{Content: "Invalid duration, use e.g. 30m or 2h", S: component.Style{Color: color.Red}})
+			}
+
+			if err := p.permissions.UserAddTemporaryGroup(c.Context, UUID, group, duration); err != nil {
+				return err
+			}
+
+			suffix = " for " + duration.String()
+		} else {
+			// A temporary membership may be made permanent, so only permanent ones are duplicates
+			if groups, _ := p.permissions.UserGroups(UUID); slices.Contains(groups, group) {
+				return c.SendMessage(&component.Text{
+					Extra: []component.Component{
+						&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
+						&component.Text{Content: name, S: component.Style{Color: color.LightPurple}},
+						&component.Text{Content: " is already a member of ", S: component.Style{Color: color.Red}},
+						&component.Text{Content: group, S: component.Style{Color: color.LightPurple}},
+					},
+				})
+			}
+
+			if err := p.permissions.UserAddGroup(c.Context, UUID, group); err != nil {
+				return err
+			}
+		}
+
+		return c.SendMessage(&component.Text{
+			Extra: []component.Component{
+				&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
+				&component.Text{Content: "Added ", S: component.Style{Color: color.Green}},
+				&component.Text{Content: name, S: component.Style{Color: color.LightPurple}},
+				&component.Text{Content: " to ", S: component.Style{Color: color.Green}},
+				&component.Text{Content: group, S: component.Style{Color: color.LightPurple}},
+				&component.Text{Content: suffix, S: component.Style{Color: color.Green}},
+			},
+		})
+	})
+}
+
+// userGroupRemoveCommand ends the permanent and temporary membership of a player in a group.
+func (p *PermissionsPlugin) userGroupRemoveCommand() brigodier.Command {
+	return command.Command(func(c *command.Context) error {
+		if !c.Source.HasPermission("permissions.remove") {
+			return PermissionMissingCommand().Run(c.CommandContext)
+		}
+
+		group := c.Arguments["group"].Result.(string)
+		name := c.Arguments["name"].Result.(string)
+
+		UUID, err := uuid.UsernameToUUID(name)
+		if err != nil {
+			return err
+		}
+
+		UUID = uuid.Normalize(UUID)
+
+		if !p.permissions.UserHasGroup(UUID, group) {
+			return c.SendMessage(&component.Text{
+				Extra: []component.Component{
+					&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
+					&component.Text{Content: name, S: component.Style{Color: color.LightPurple}},
+					&component.Text{Content: " is not a member of ", S: component.Style{Color: color.Red}},
+					&component.Text{Content: group, S: component.Style{Color: color.LightPurple}},
+				},
+			})
+		}
+
+		if err := p.permissions.UserRemoveGroup(c.Context, UUID, group); err != nil {
+			return err
+		}
+
+		return c.SendMessage(&component.Text{
+			Extra: []component.Component{
+				&component.Text{Content: "ᴘᴇʀᴍѕ ", S: component.Style{Color: color.Green, Bold: component.True}},
+				&component.Text{Content: "Removed ", S: component.Style{Color: color.Green}},
+				&component.Text{Content: name, S: component.Style{Color: color.LightPurple}},
+				&component.Text{Content: " from ", S: component.Style{Color: color.Green}},
+				&component.Text{Content: group, S: component.Style{Color: color.LightPurple}},
+			},
+		})
+	})
+}
+
 func (p *PermissionsPlugin) reloadCommand() brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "permissions.reload") {
+		if !c.Source.HasPermission("permissions.reload") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		if err := p.permissions.Reload(c.Context); err != nil {
@@ -0,0 +1,295 @@
+package permissions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting/kv"
+)
+
+// Every user and group is stored under its own key, so proxies editing different entries don't conflict.
+const (
+	userKeyPrefix   = "user."
+	groupKeyPrefix  = "group."
+	defaultGroupKey = "defaultGroup"
+
+	// legacyUsersKey and legacyGroupsKey held all users and groups in a single value. They are split into
+	// per-key entries on reload.
+	legacyUsersKey  = "users"
+	legacyGroupsKey = "groups"
+)
+
+// maxUpdateAttempts is how often an update is retried when the entry keeps changing concurrently.
+const maxUpdateAttempts = 10
+
+var (
+	ErrConflict = errors.New("permission entry was changed concurrently")
+)
+
+func userKey(uuid string) string {
+	return userKeyPrefix + uuid
+}
+
+func groupKey(name string) string {
+	return groupKeyPrefix + name
+}
+
+// update applies fn to the entry stored under key and writes the result, returning it with the revision it
+// was written at. Writes are optimistic: if the entry changed between reading it and writing the result, fn
+// is applied again to the new entry.
+func update[T any](ctx context.Context, bucket kv.Bucket, key string, fn func(*T) error) (T, uint64, error) {
+	var zero T
+
+	for range maxUpdateAttempts {
+		var value T
+
+		entry, err := bucket.GetEntry(ctx, key)
+		if errors.Is(err, kv.ErrKeyNotFound) {
+			entry = nil
+		} else if err != nil {
+			return zero, 0, err
+		} else if err := json.Unmarshal(entry.Value, &value); err != nil {
+			return zero, 0, err
+		}
+
+		if err := fn(&value); err != nil {
+			return zero, 0, err
+		}
+
+		updated, err := json.Marshal(value)
+		if err != nil {
+			return zero, 0, err
+		}
+
+		var revision uint64
+		if entry == nil {
+			revision, err = bucket.Create(ctx, key, updated)
+		} else {
+			revision, err = bucket.Update(ctx, key, updated, entry.Revision)
+		}
+
+		if errors.Is(err, kv.ErrKeyExists) || errors.Is(err, kv.ErrRevisionMismatch) {
+			continue
+		} else if err != nil {
+			return zero, 0, err
+		}
+
+		return value, revision, nil
+	}
+
+	return zero, 0, ErrConflict
+}
+
+// updateUser updates a user and caches the result, unless the watcher already applied a newer change.
+func (p *Permissions) updateUser(ctx context.Context, uuid string, fn func(*PermissionUser) error) error {
+	user, revision, err := update(ctx, p.kv, userKey(uuid), fn)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	if p.cache(userKey(uuid), revision) {
+		p.Users[uuid] = user
+	}
+	p.m.Unlock()
+
+	p.invalidateUser(uuid)
+
+	return nil
+}
+
+// updateGroup updates a group and caches the result, unless the watcher already applied a newer change.
+func (p *Permissions) updateGroup(ctx context.Context, name string, fn func(*PermissionGroup) error) error {
+	group, revision, err := update(ctx, p.kv, groupKey(name), fn)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	if p.cache(groupKey(name), revision) {
+		p.Groups[name] = group
+	}
+	p.m.Unlock()
+
+	p.invalidate()
+
+	return nil
+}
+
+// cache records that the entry stored under key was written at revision and reports whether the cached
+// entry is older, so it has to be replaced. p.m must be locked.
+func (p *Permissions) cache(key string, revision uint64) bool {
+	if revision <= p.revisions[key] {
+		return false
+	}
+
+	p.revisions[key] = revision
+
+	return true
+}
+
+// apply updates the entry stored under the changed key. Changes are applied in the order the watcher
+// delivers them, which is the order they were written in.
+func (p *Permissions) apply(key *kv.Value) error {
+	switch {
+	case strings.HasPrefix(key.Key, userKeyPrefix):
+		uuid := strings.TrimPrefix(key.Key, userKeyPrefix)
+
+		if key.Operation == kv.Delete {
+			p.m.Lock()
+			delete(p.Users, uuid)
+			p.revisions[key.Key] = key.Revision
+			p.m.Unlock()
+		} else {
+			user := PermissionUser{}
+			if err := json.Unmarshal(key.Value, &user); err != nil {
+				return err
+			}
+
+			p.m.Lock()
+			p.Users[uuid] = user
+			p.revisions[key.Key] = key.Revision
+			p.m.Unlock()
+		}
+
+		p.invalidateUser(uuid)
+
+	case strings.HasPrefix(key.Key, groupKeyPrefix):
+		name := strings.TrimPrefix(key.Key, groupKeyPrefix)
+
+		if key.Operation == kv.Delete {
+			p.m.Lock()
+			delete(p.Groups, name)
+			p.revisions[key.Key] = key.Revision
+			p.m.Unlock()
+		} else {
+			group := PermissionGroup{}
+			if err := json.Unmarshal(key.Value, &group); err != nil {
+				return err
+			}
+
+			p.m.Lock()
+			p.Groups[name] = group
+			p.revisions[key.Key] = key.Revision
+			p.m.Unlock()
+		}
+
+		// Groups may be inherited by any user
+		p.invalidate()
+
+	case key.Key == defaultGroupKey:
+		defaultGroup := DefaultGroup
+		if key.Operation != kv.Delete {
+			if err := json.Unmarshal(key.Value, &defaultGroup); err != nil {
+				return err
+			}
+		}
+
+		p.m.Lock()
+		p.DefaultGroup = defaultGroup
+		p.m.Unlock()
+
+		p.invalidate()
+	}
+
+	return nil
+}
+
+// load reads all users and groups from the bucket, migrating the legacy keys first.
+func (p *Permissions) load(ctx context.Context) error {
+	if err := p.migrate(ctx); err != nil {
+		return err
+	}
+
+	keys, err := p.kv.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]PermissionUser)
+	groups := make(map[string]PermissionGroup)
+	revisions := make(map[string]uint64)
+	defaultGroup := DefaultGroup
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, userKeyPrefix) && !strings.HasPrefix(key, groupKeyPrefix) && key != defaultGroupKey {
+			continue
+		}
+
+		entry, err := p.kv.GetEntry(ctx, key)
+		if errors.Is(err, kv.ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		raw := entry.Value
+		revisions[key] = entry.Revision
+
+		switch {
+		case strings.HasPrefix(key, userKeyPrefix):
+			user := PermissionUser{}
+			err = json.Unmarshal(raw, &user)
+			users[strings.TrimPrefix(key, userKeyPrefix)] = user
+		case strings.HasPrefix(key, groupKeyPrefix):
+			group := PermissionGroup{}
+			err = json.Unmarshal(raw, &group)
+			groups[strings.TrimPrefix(key, groupKeyPrefix)] = group
+		default:
+			err = json.Unmarshal(raw, &defaultGroup)
+		}
+
+		if err != nil {
+			p.l.Error().Err(err).Msgf("Failed to unmarshal %s key", key)
+		}
+	}
+
+	p.m.Lock()
+	p.Users = users
+	p.Groups = groups
+	p.revisions = revisions
+	p.DefaultGroup = defaultGroup
+	p.m.Unlock()
+
+	p.invalidate()
+
+	return nil
+}
+
+// migrate splits the legacy users and groups keys into one key per entry. Entries that already exist
+// under their own key are kept.
+func (p *Permissions) migrate(ctx context.Context) error {
+	if err := migrateKey(ctx, p.kv, legacyUsersKey, userKey); err != nil {
+		return err
+	}
+
+	return migrateKey(ctx, p.kv, legacyGroupsKey, groupKey)
+}
+
+func migrateKey(ctx context.Context, bucket kv.Bucket, legacyKey string, key func(string) string) error {
+	raw, err := bucket.Get(ctx, legacyKey)
+	if errors.Is(err, kv.ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entries := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return err
+	}
+
+	for name, entry := range entries {
+		if _, err := bucket.Create(ctx, key(name), entry); err != nil && !errors.Is(err, kv.ErrKeyExists) {
+			return err
+		}
+	}
+
+	if err := bucket.Delete(ctx, legacyKey); err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+		return err
+	}
+
+	return nil
+}
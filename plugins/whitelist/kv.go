@@ -23,22 +23,22 @@ type Whitelist struct {
 }
 
 func NewKVWhitelist(ctx context.Context, h *hosting.Hosting) (*Whitelist, error) {
-	kv, err := h.KV().Bucket(ctx, h.Info.KVNetworkKey()+"_whitelist")
+	bucket, err := h.KV().Bucket(ctx, h.Info.KVNetworkKey()+"_whitelist")
 	if err != nil {
 		return nil, err
 	}
 
-	l := log.With().Str("bucket", kv.Name()).Logger()
+	l := log.With().Str("bucket", bucket.Name()).Logger()
 
 	w := &Whitelist{
 		Enabled:     false,
 		Whitelisted: make([]string, 0),
 		h:           h,
-		kv:          kv,
+		kv:          bucket,
 		l:           l,
 	}
 
-	watcher, err := kv.WatchAll(context.Background())
+	watcher, err := kv.WatchResuming(context.Background(), bucket, ">")
 	if err != nil {
 		return nil, err
 	}
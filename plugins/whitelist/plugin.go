@@ -8,7 +8,6 @@ import (
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/internal/hosting"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/util"
 	"github.com/Community-Sourced-Minecraft/Gate-Proxy/lib/util/uuid"
-	"github.com/Community-Sourced-Minecraft/Gate-Proxy/plugins/permissions"
 	"github.com/robinbraemer/event"
 	"go.minekube.com/brigodier"
 	"go.minekube.com/common/minecraft/color"
@@ -18,21 +17,19 @@ import (
 )
 
 type WhitelistPlugin struct {
-	whitelist   *Whitelist
-	permissions *permissions.Permissions
-	h           *hosting.Hosting
+	whitelist *Whitelist
+	h         *hosting.Hosting
 }
 
-func NewPlugin(h *hosting.Hosting, permissions *permissions.Permissions) (*WhitelistPlugin, error) {
+func NewPlugin(h *hosting.Hosting) (*WhitelistPlugin, error) {
 	whitelist, err := NewKVWhitelist(context.Background(), h)
 	if err != nil {
 		return nil, err
 	}
 
 	return &WhitelistPlugin{
-		whitelist:   whitelist,
-		permissions: permissions,
-		h:           h,
+		whitelist: whitelist,
+		h:         h,
 	}, nil
 }
 
@@ -66,11 +63,11 @@ func (p *WhitelistPlugin) onPostConnectEvent(e *proxy.ServerPostConnectEvent) {
 	}
 }
 
-func New(h *hosting.Hosting, permissions *permissions.Permissions) (proxy.Plugin, error) {
+func New(h *hosting.Hosting) (proxy.Plugin, error) {
 	return proxy.Plugin{
 		Name: "Whitelist",
 		Init: func(ctx context.Context, px *proxy.Proxy) error {
-			plugin, err := NewPlugin(h, permissions)
+			plugin, err := NewPlugin(h)
 			if err != nil {
 				return err
 			}
@@ -120,7 +117,7 @@ func (p *WhitelistPlugin) UsageWhitelist() brigodier.Command {
 	usage := component.Text{Content: "Usage: /whitelist <add/remove/enable/disable> <user>", S: component.Style{Color: color.Red}}
 
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		return c.SendMessage(&usage)
@@ -140,7 +137,7 @@ func PermissionMissingCommand() brigodier.Command {
 
 func (p *WhitelistPlugin) addCommand() brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 
@@ -168,7 +165,7 @@ func (p *WhitelistPlugin) addCommand() brigodier.Command {
 
 func (p *WhitelistPlugin) removeCommand() brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		username := c.Arguments["user"].Result.(string)
@@ -197,7 +194,7 @@ func (p *WhitelistPlugin) reloadCommand() brigodier.Command {
 	reloaded := component.Text{Content: "Reloaded command successfully!", S: component.Style{Color: color.Green}}
 
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 
@@ -211,7 +208,7 @@ func (p *WhitelistPlugin) reloadCommand() brigodier.Command {
 
 func (p *WhitelistPlugin) listCommand() brigodier.Command {
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		users := strings.Builder{}
@@ -241,7 +238,7 @@ func (p *WhitelistPlugin) enableCommand() brigodier.Command {
 	enabled := component.Text{Content: "Enabled whitelist!", S: component.Style{Color: color.Green}}
 
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		if p.whitelist.IsEnabled() {
@@ -261,7 +258,7 @@ func (p *WhitelistPlugin) disableCommand() brigodier.Command {
 	disabled := component.Text{Content: "Disabled whitelist!", S: component.Style{Color: color.Green}}
 
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		if !p.whitelist.IsEnabled() {
@@ -282,7 +279,7 @@ func (p *WhitelistPlugin) statusCommand() brigodier.Command {
 	disabled := component.Text{Content: "disabled", S: component.Style{Color: color.Red}}
 
 	return command.Command(func(c *command.Context) error {
-		if !p.permissions.UserHasPermission(c.Source.(proxy.Player).ID().String(), "whitelist.add") {
+		if !c.Source.HasPermission("whitelist.add") {
 			return PermissionMissingCommand().Run(c.CommandContext)
 		}
 		var state component.Text